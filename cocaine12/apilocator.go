@@ -70,6 +70,40 @@ func newLocatorServiceInfo() *ServiceInfo {
 					},
 				},
 			},
+			// publish is not called by the framework,
+			// it keeps the id of routing as in cocaine-core
+			4: dispatchItem{
+				Name:       "publish",
+				Downstream: emptyDescription,
+				Upstream: &streamDescription{
+					0: &StreamDescriptionItem{
+						Name:        "value",
+						Description: emptyDescription,
+					},
+					1: &StreamDescriptionItem{
+						Name:        "error",
+						Description: emptyDescription,
+					},
+				},
+			},
+			5: dispatchItem{
+				Name:       "routing",
+				Downstream: emptyDescription,
+				Upstream: &streamDescription{
+					0: &StreamDescriptionItem{
+						Name:        "write",
+						Description: recursiveDescription,
+					},
+					1: &StreamDescriptionItem{
+						Name:        "error",
+						Description: emptyDescription,
+					},
+					2: &StreamDescriptionItem{
+						Name:        "close",
+						Description: emptyDescription,
+					},
+				},
+			},
 		},
 	}
 }
@@ -2,9 +2,12 @@ package cocaine12
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ugorji/go/codec"
@@ -23,6 +26,56 @@ var (
 
 type asyncSender interface {
 	Send(*Message)
+	// SendContext is Send which gives up once ctx is done
+	SendContext(context.Context, *Message) error
+}
+
+// wireFormat packs messages to the wire and unpacks them from it
+type wireFormat interface {
+	encode(enc *codec.Encoder, msg *Message) error
+	decode(r *bufio.Reader) (*Message, error)
+}
+
+// defaultWireFormat lays a message out as
+// [session, type, payload, headers]. It's used by services
+// and the worker protocol v1.
+type defaultWireFormat struct{}
+
+func (defaultWireFormat) encode(enc *codec.Encoder, msg *Message) error {
+	return enc.Encode(msg)
+}
+
+func (defaultWireFormat) decode(r *bufio.Reader) (*Message, error) {
+	frame, err := readRawFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := rawSlice{data: frame}
+	n, err := s.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 3 {
+		return nil, fmt.Errorf("malformed frame of %d items", n)
+	}
+
+	var message Message
+	if message.Session, err = s.uint(); err != nil {
+		return nil, err
+	}
+	if message.MsgType, err = s.uint(); err != nil {
+		return nil, err
+	}
+	if message.rawPayload, err = s.next(); err != nil {
+		return nil, err
+	}
+	if n > 3 {
+		if message.rawHeaders, err = s.next(); err != nil {
+			return nil, err
+		}
+	}
+	return &message, nil
 }
 
 type socketIO interface {
@@ -31,6 +84,7 @@ type socketIO interface {
 	Write() chan *Message
 	IsClosed() <-chan struct{}
 	Close()
+	FlushStats() FlushStats
 }
 
 type asyncBuff struct {
@@ -39,13 +93,22 @@ type asyncBuff struct {
 
 	stop chan (<-chan time.Time)
 	wait chan struct{}
+
+	// queued counts the messages which have entered the buffer
+	// and haven't been taken, see take
+	queued atomic.Int64
+
+	// the buffer stops reading in at the high watermark
+	watermarks Watermarks
 }
 
-func newAsyncBuf() *asyncBuff {
+func newAsyncBuf(watermarks Watermarks) *asyncBuff {
 	buf := &asyncBuff{
 		in:  make(chan *Message),
 		out: make(chan *Message),
 
+		watermarks: watermarks,
+
 		// to stop my loop
 		stop: make(chan (<-chan time.Time)),
 		// to wait for a notifycation
@@ -68,9 +131,8 @@ func (bf *asyncBuff) loop() {
 			// buffer for messages
 			pending []*Message
 
-			// it should be read until closed
-			// to get all messages from a sender
-			input = bf.in
+			// pending messages against the watermarks
+			level = bufferLevel{Watermarks: bf.watermarks}
 
 			// if <-chan time.Time is received we have to wait the buffer drainig
 			// if closed return immediatly
@@ -86,8 +148,17 @@ func (bf *asyncBuff) loop() {
 			var (
 				candidate *Message
 				out       chan *Message
+
+				// it should be read until closed
+				// to get all messages from a sender,
+				// unless the buffer is full
+				input chan *Message
 			)
 
+			if !finished && level.accepting() {
+				input = bf.in
+			}
+
 			if len(pending) > 0 {
 				// mark the first message as a candidate to be sent
 				// and unlock the sending state
@@ -104,16 +175,19 @@ func (bf *asyncBuff) loop() {
 			case incoming, open := <-input:
 				if open {
 					pending = append(pending, incoming)
+					level.push(incoming)
+					bf.queued.Add(1)
 				} else {
 					// Set the flag
-					// Unset channel to lock the case
+					// to lock the case
 					finished = true
-					input = nil
 				}
 
 			// send the first message from the queue to a reveiver
 			case out <- candidate:
+				pending[0] = nil
 				pending = pending[1:]
+				level.pop(candidate)
 
 			case timeoutChan, open := <-stopped:
 				if !open {
@@ -134,6 +208,22 @@ func (bf *asyncBuff) loop() {
 	}()
 }
 
+// take receives a message from out until the deadline fires.
+// more tells that other messages are queued, so the next take
+// returns without waiting. ok is false if the deadline has fired
+// or the buffer is closed.
+func (bf *asyncBuff) take(deadline <-chan time.Time) (msg *Message, more bool, ok bool) {
+	select {
+	case msg, ok = <-bf.out:
+		if !ok {
+			return nil, false, false
+		}
+		return msg, bf.queued.Add(-1) > 0, true
+	case <-deadline:
+		return nil, false, false
+	}
+}
+
 // Stop stops a loop which is handling messages in the buffer
 // It is prohibited to call Drain afer Stop
 func (bf *asyncBuff) Stop() error {
@@ -157,6 +247,62 @@ func (bf *asyncBuff) Drain(d time.Duration) error {
 	return bf.Stop()
 }
 
+// WriteBatching bounds the frames which a socket coalesces
+// into a single flush. The zero fields get the defaults.
+type WriteBatching struct {
+	// MaxBytes flushes a batch once that many bytes are encoded
+	MaxBytes int
+	// MaxDelay is how long the first frame of a batch may wait
+	// for more frames. With zero the batch is flushed as soon as
+	// no more frames are pending, so no latency is added.
+	MaxDelay time.Duration
+}
+
+const defaultWriteBatchBytes = 64 * 1024
+
+// DefaultWriteBatching flushes up to 64KiB without waiting for frames
+func DefaultWriteBatching() WriteBatching {
+	return WriteBatching{MaxBytes: defaultWriteBatchBytes}
+}
+
+func (b WriteBatching) withDefaults() WriteBatching {
+	if b.MaxBytes == 0 {
+		b.MaxBytes = defaultWriteBatchBytes
+	}
+	return b
+}
+
+func (b WriteBatching) validate() error {
+	if b.MaxBytes < 0 {
+		return fmt.Errorf("max batch bytes must not be negative, got %d", b.MaxBytes)
+	}
+	if b.MaxDelay < 0 {
+		return fmt.Errorf("max batch delay must not be negative, got %v", b.MaxDelay)
+	}
+	return nil
+}
+
+// FlushStats counts the flushes of the frames written to a socket
+type FlushStats struct {
+	Flushes uint64
+	Frames  uint64
+	Bytes   uint64
+}
+
+// FramesPerFlush returns the average number of frames in a flush
+func (s FlushStats) FramesPerFlush() float64 {
+	if s.Flushes == 0 {
+		return 0
+	}
+	return float64(s.Frames) / float64(s.Flushes)
+}
+
+type flushCounters struct {
+	flushes atomic.Uint64
+	frames  atomic.Uint64
+	bytes   atomic.Uint64
+}
+
 // Biderectional socket
 type asyncRWSocket struct {
 	sync.Mutex
@@ -164,14 +310,42 @@ type asyncRWSocket struct {
 	upstreamBuf   *asyncBuff
 	downstreamBuf *asyncBuff
 	closed        chan struct{} // broadcast channel
+	format        wireFormat
+	batching      WriteBatching
+	flushes       flushCounters
+}
+
+// socketOptions tune the buffers of asyncRWSocket
+type socketOptions struct {
+	batching   WriteBatching
+	watermarks Watermarks
+}
+
+func defaultSocketOptions() socketOptions {
+	return socketOptions{
+		batching: DefaultWriteBatching(),
+	}
 }
 
 func newAsyncRW(conn io.ReadWriteCloser) (*asyncRWSocket, error) {
+	return newAsyncRWWithFormat(conn, defaultWireFormat{})
+}
+
+func newAsyncRWWithFormat(conn io.ReadWriteCloser, format wireFormat) (*asyncRWSocket, error) {
+	return newAsyncRWWithOptions(conn, format, defaultSocketOptions())
+}
+
+// newAsyncRWWithOptions makes a socket. The buffers stop taking messages
+// at the high watermarks: Send blocks and the socket isn't read,
+// so TCP pushes back on the remote side.
+func newAsyncRWWithOptions(conn io.ReadWriteCloser, format wireFormat, options socketOptions) (*asyncRWSocket, error) {
 	sock := &asyncRWSocket{
 		conn:          conn,
-		upstreamBuf:   newAsyncBuf(),
-		downstreamBuf: newAsyncBuf(),
+		upstreamBuf:   newAsyncBuf(options.watermarks),
+		downstreamBuf: newAsyncBuf(options.watermarks),
 		closed:        make(chan struct{}),
+		format:        format,
+		batching:      options.batching.withDefaults(),
 	}
 
 	sock.readloop()
@@ -189,6 +363,10 @@ func newTCPConnection(address string, timeout time.Duration) (socketIO, error) {
 }
 
 func newAsyncConnection(family string, address string, timeout time.Duration) (socketIO, error) {
+	return newAsyncConnectionWithFormat(family, address, timeout, defaultWireFormat{}, defaultSocketOptions())
+}
+
+func newAsyncConnectionWithFormat(family string, address string, timeout time.Duration, format wireFormat, options socketOptions) (socketIO, error) {
 	dialer := net.Dialer{
 		Timeout:   timeout,
 		DualStack: true,
@@ -198,7 +376,7 @@ func newAsyncConnection(family string, address string, timeout time.Duration) (s
 	if err != nil {
 		return nil, err
 	}
-	return newAsyncRW(conn)
+	return newAsyncRWWithOptions(conn, format, options)
 }
 
 func (sock *asyncRWSocket) Close() {
@@ -240,33 +418,135 @@ func (sock *asyncRWSocket) Send(msg *Message) {
 	}
 }
 
+func (sock *asyncRWSocket) SendContext(ctx context.Context, msg *Message) error {
+	// ctx matters only if the buffer is full
+	select {
+	case sock.Write() <- msg:
+		return nil
+	default:
+	}
+
+	select {
+	case sock.Write() <- msg:
+		return nil
+	case <-sock.IsClosed():
+		return io.ErrClosedPipe
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// FlushStats returns the counters of the flushes
+func (sock *asyncRWSocket) FlushStats() FlushStats {
+	return FlushStats{
+		Flushes: sock.flushes.flushes.Load(),
+		Frames:  sock.flushes.frames.Load(),
+		Bytes:   sock.flushes.bytes.Load(),
+	}
+}
+
+// writeloop encodes the pending messages into a batch and flushes it
+// at once, so a burst of small chunks doesn't cost a syscall per chunk
 func (sock *asyncRWSocket) writeloop() {
 	go func() {
-		var buf = bufio.NewWriter(sock.conn)
-		encoder := codec.NewEncoder(buf, hAsocket)
-		for incoming := range sock.upstreamBuf.out {
-			err := encoder.Encode(incoming)
-			if err != nil {
-				sock.close()
-				// blackhole all pending writes. See #31
-				go func() {
-					for _ = range sock.upstreamBuf.out {
-						// pass
-					}
-				}()
+		var (
+			buf     = bufio.NewWriterSize(sock.conn, sock.batching.MaxBytes)
+			counter = &countingWriter{w: buf}
+			encoder = codec.NewEncoder(counter, hAsocket)
+			delay   *time.Timer
+		)
+
+		if sock.batching.MaxDelay > 0 {
+			delay = time.NewTimer(sock.batching.MaxDelay)
+			stopTimer(delay)
+		}
+
+		for {
+			incoming, more, open := sock.upstreamBuf.take(nil)
+			if !open {
 				return
 			}
-			buf.Flush()
+
+			var deadline <-chan time.Time
+			if delay != nil {
+				delay.Reset(sock.batching.MaxDelay)
+				deadline = delay.C
+			}
+
+			var frames uint64
+			counter.n = 0
+			for open {
+				if err := sock.format.encode(encoder, incoming); err != nil {
+					sock.fail()
+					return
+				}
+				frames++
+
+				if counter.n >= sock.batching.MaxBytes {
+					break
+				}
+
+				switch {
+				case more:
+					incoming, more, open = sock.upstreamBuf.take(nil)
+				case deadline != nil:
+					incoming, more, open = sock.upstreamBuf.take(deadline)
+				default:
+					open = false
+				}
+			}
+
+			if delay != nil {
+				stopTimer(delay)
+			}
+
+			if err := buf.Flush(); err != nil {
+				sock.fail()
+				return
+			}
+			sock.flushes.flushes.Add(1)
+			sock.flushes.frames.Add(frames)
+			sock.flushes.bytes.Add(uint64(counter.n))
 		}
 	}()
 }
 
+// fail closes the socket on a write error
+func (sock *asyncRWSocket) fail() {
+	sock.close()
+	// blackhole all pending writes. See #31
+	go func() {
+		for _ = range sock.upstreamBuf.out {
+			// pass
+		}
+	}()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
 func (sock *asyncRWSocket) readloop() {
 	go func() {
-		decoder := codec.NewDecoder(bufio.NewReader(sock.conn), hAsocket)
+		reader := bufio.NewReader(sock.conn)
 		for {
-			var message *Message
-			err := decoder.Decode(&message)
+			message, err := sock.format.decode(reader)
 			if err != nil {
 				close(sock.downstreamBuf.in)
 				sock.close()
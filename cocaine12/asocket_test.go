@@ -1,15 +1,18 @@
 package cocaine12
 
 import (
+	"io"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
 )
 
 func TestASocketDrain(t *testing.T) {
 	var exit = make(chan struct{})
-	buff := newAsyncBuf()
+	buff := newAsyncBuf(DefaultWatermarks())
 
 	var (
 		count    = 0
@@ -41,3 +44,81 @@ func TestASocketConnect(t *testing.T) {
 	_, err = newUnixConnection("unix.sock", time.Second)
 	assert.Error(t, err)
 }
+
+// writesConn records the writes and blocks reads until it's closed
+type writesConn struct {
+	sync.Mutex
+	writes int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *writesConn) Read(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *writesConn) Write(p []byte) (int, error) {
+	c.Lock()
+	c.writes++
+	c.Unlock()
+	return len(p), nil
+}
+
+func (c *writesConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestASocketWriteBatching(t *testing.T) {
+	msg := newChunkV1(1, []byte("chunk"))
+	var frame []byte
+	assert.NoError(t, codec.NewEncoderBytes(&frame, hAsocket).Encode(msg))
+
+	waitFrames := func(sock *asyncRWSocket, frames uint64) FlushStats {
+		deadline := time.After(5 * time.Second)
+		for {
+			stats := sock.FlushStats()
+			if stats.Frames >= frames {
+				return stats
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("%d frames of %d are flushed", stats.Frames, frames)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+
+	// a batch is flushed once it's full
+	conn := &writesConn{closed: make(chan struct{})}
+	sock, _ := newAsyncRWWithOptions(conn, defaultWireFormat{}, socketOptions{
+		batching: WriteBatching{MaxBytes: 5 * len(frame), MaxDelay: time.Hour},
+	})
+	defer sock.Close()
+
+	for i := 0; i < 10; i++ {
+		sock.Write() <- msg
+	}
+	stats := waitFrames(sock, 10)
+	assert.Equal(t, uint64(2), stats.Flushes)
+	assert.Equal(t, uint64(10*len(frame)), stats.Bytes)
+	assert.Equal(t, 5.0, stats.FramesPerFlush())
+	conn.Lock()
+	assert.Equal(t, 2, conn.writes)
+	conn.Unlock()
+
+	// or once the delay is over
+	conn = &writesConn{closed: make(chan struct{})}
+	sock, _ = newAsyncRWWithOptions(conn, defaultWireFormat{}, socketOptions{
+		batching: WriteBatching{MaxDelay: 10 * time.Millisecond},
+	})
+	defer sock.Close()
+
+	sock.Write() <- msg
+	stats = waitFrames(sock, 1)
+	assert.Equal(t, uint64(1), stats.Flushes)
+
+	assert.Error(t, WriteBatching{MaxBytes: -1}.validate())
+	assert.Error(t, WriteBatching{MaxDelay: -1}.validate())
+}
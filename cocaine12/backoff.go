@@ -0,0 +1,54 @@
+package cocaine12
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff is a jittered exponential backoff:
+// the delay of the attempt n is Initial * Multiplier^n up to Max,
+// randomly spread by the Jitter fraction of it
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter is in [0, 1]
+	Jitter float64
+}
+
+// DefaultBackoff starts from 100ms and doubles up to 10s with 20% jitter
+func DefaultBackoff() Backoff {
+	return Backoff{
+		Initial:    100 * time.Millisecond,
+		Max:        10 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
+// Delay returns the delay before the attempt, attempts start from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	delay *= 1 + b.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+func (b Backoff) validate() error {
+	switch {
+	case b.Initial <= 0:
+		return fmt.Errorf("initial delay must be positive, got %v", b.Initial)
+	case b.Max < b.Initial:
+		return fmt.Errorf("max delay %v is less than initial %v", b.Max, b.Initial)
+	case b.Multiplier < 1:
+		return fmt.Errorf("multiplier must not be less than 1, got %v", b.Multiplier)
+	case b.Jitter < 0 || b.Jitter > 1:
+		return fmt.Errorf("jitter must be in [0, 1], got %v", b.Jitter)
+	}
+	return nil
+}
@@ -9,6 +9,10 @@ import (
 type Channel interface {
 	Rx
 	Tx
+	// Cancel detaches the session from the service: the following
+	// replies are dropped and Get returns ErrStreamIsClosed.
+	// A session is cancelled as well when the context of the call is done.
+	Cancel()
 }
 
 type Rx interface {
@@ -26,6 +30,9 @@ type channel struct {
 	traceReceived CloseSpan
 	// we call when data is sent
 	traceSent CloseSpan
+	// reports the outcome of the call to the circuit breaker
+	guard      *breakerCall
+	firstReply sync.Once
 
 	rx
 	tx
@@ -33,23 +40,69 @@ type channel struct {
 
 func (ch *channel) push(res ServiceResult) {
 	ch.traceReceived()
+	// the first reply settles the call even if nobody gets it.
+	// The protocol is not advanced by Get until the reply is queued.
+	ch.firstReply.Do(func() {
+		var err error
+		if (*ch.rxTree)[res.methodID()].Name == "error" {
+			err = errReplyIsError
+		}
+		ch.guard.done(err)
+	})
 	ch.rx.push(res)
 }
 
+func (ch *channel) Cancel() {
+	ch.guard.cancel()
+	ch.rx.cancel()
+}
+
+// cancelOnDone cancels the session when the context is done,
+// a deadline counts as a failure for the circuit breaker
+func (ch *channel) cancelOnDone(ctx context.Context) {
+	ch.rx.Lock()
+	defer ch.rx.Unlock()
+
+	if ch.rx.done {
+		return
+	}
+
+	ch.rx.stopCancel = context.AfterFunc(ctx, func() {
+		ch.guard.done(ctx.Err())
+		ch.rx.cancel()
+	})
+}
+
+func (ch *channel) Get(ctx context.Context) (ServiceResult, error) {
+	res, err := ch.rx.Get(ctx)
+	if err != nil {
+		ch.guard.done(err)
+	} else {
+		ch.guard.done(res.Err())
+	}
+	return res, err
+}
+
 func (ch *channel) Call(ctx context.Context, name string, args ...interface{}) error {
 	ch.traceSent()
 	return ch.tx.Call(ctx, name, args...)
 }
 
 type rx struct {
-	service    *Service
+	// sessions of the connection the channel is opened on
+	sessions   *sessions
 	pushBuffer chan ServiceResult
 	rxTree     *streamDescription
 	id         uint64
 
+	// closed by cancel to wake up Get
+	cancelled chan struct{}
+
 	sync.Mutex
 	queue []ServiceResult
 	done  bool
+	// stops the cancellation on the context of the call
+	stopCancel func() bool
 }
 
 func (rx *rx) Get(ctx context.Context) (ServiceResult, error) {
@@ -78,16 +131,19 @@ func (rx *rx) Get(ctx context.Context) (ServiceResult, error) {
 		case res = <-rx.pushBuffer:
 		case <-ctx.Done():
 			return nil, ctx.Err()
+		case <-rx.cancelled:
+			return nil, ErrStreamIsClosed
 		}
 	}
 
 	treeMap := *(rx.rxTree)
-	method, _, _ := res.Result()
-	temp := treeMap[method]
+	temp := treeMap[res.methodID()]
 
 	switch temp.Description.Type() {
 	case emptyDispatch:
+		rx.Lock()
 		rx.done = true
+		rx.Unlock()
 	case recursiveDispatch:
 		// pass
 	case otherDispatch:
@@ -117,10 +173,34 @@ func (rx *rx) Get(ctx context.Context) (ServiceResult, error) {
 }
 
 func (rx *rx) Closed() bool {
+	rx.Lock()
+	defer rx.Unlock()
 	return rx.done
 }
 
+func (rx *rx) cancel() {
+	rx.Lock()
+	if rx.done {
+		rx.Unlock()
+		return
+	}
+
+	rx.done = true
+	rx.queue = nil
+	close(rx.cancelled)
+	if rx.stopCancel != nil {
+		rx.stopCancel()
+	}
+	rx.Unlock()
+
+	rx.sessions.Detach(rx.id)
+}
+
 func (rx *rx) push(res ServiceResult) {
+	// res must not be touched once it's queued
+	// as Get may set an error to it concurrently
+	method := res.methodID()
+
 	rx.Lock()
 	rx.queue = append(rx.queue, res)
 	select {
@@ -131,17 +211,23 @@ func (rx *rx) push(res ServiceResult) {
 	rx.Unlock()
 
 	treeMap := *(rx.rxTree)
-	method, _, _ := res.Result()
 	if temp := treeMap[method]; temp.Description.Type() == emptyDispatch {
-		rx.service.sessions.Detach(rx.id)
+		rx.sessions.Detach(rx.id)
+
+		rx.Lock()
+		if rx.stopCancel != nil {
+			rx.stopCancel()
+		}
+		rx.Unlock()
 	}
 }
 
 type tx struct {
-	service *Service
-	txTree  *streamDescription
-	id      uint64
-	done    bool
+	// sends a message to the connection the channel is opened on
+	send   func(*Message)
+	txTree *streamDescription
+	id     uint64
+	done   bool
 
 	headers CocaineHeaders
 }
@@ -176,6 +262,6 @@ func (tx *tx) Call(ctx context.Context, name string, args ...interface{}) error
 		Headers:           tx.headers,
 	}
 
-	tx.service.sendMsg(msg)
+	tx.send(msg)
 	return nil
 }
@@ -0,0 +1,69 @@
+package cocaine12_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelCancel(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	_, err = ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.OpenSessions())
+
+	// the stream is never closed by the service
+	watch := func(ctx context.Context) cocaine.Channel {
+		ch, err := s.Call(ctx, "watch")
+		if err != nil {
+			t.Fatalf("unable to call: %v", err)
+		}
+		_, err = ch.Get(ctx)
+		assert.NoError(t, err)
+		return ch
+	}
+
+	ch := watch(ctx)
+	assert.Equal(t, 1, s.OpenSessions())
+	ch.Cancel()
+	assert.Equal(t, 0, s.OpenSessions())
+	_, err = ch.Get(ctx)
+	assert.Equal(t, cocaine.ErrStreamIsClosed, err)
+
+	callCtx, callCancel := context.WithCancel(ctx)
+	ch = watch(callCtx)
+	assert.Equal(t, 1, s.OpenSessions())
+
+	// Get is woken up by the cancellation
+	result := make(chan error, 1)
+	go func() {
+		_, err := ch.Get(ctx)
+		result <- err
+	}()
+
+	callCancel()
+	assert.Equal(t, cocaine.ErrStreamIsClosed, <-result)
+	assert.Equal(t, 0, s.OpenSessions())
+	assert.True(t, ch.Closed())
+}
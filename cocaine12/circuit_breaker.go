@@ -0,0 +1,334 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Call while the circuit breaker
+// of the service is open
+var ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+var (
+	errReplyIsError = errors.New("the first reply is an error")
+	errProbeTimeout = errors.New("the probe call has got no reply")
+)
+
+// BreakerState is the state of the circuit breaker of a Service
+type BreakerState int
+
+const (
+	// BreakerClosed lets all the calls through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all the calls with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe calls through
+	// to check if the service has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerOpenTimeout = 5 * time.Second
+
+	// the window slides by a bucket
+	breakerBuckets = 10
+	// a bucket is at least a millisecond
+	minBreakerWindow = breakerBuckets * time.Millisecond
+)
+
+// BreakerPolicy configures the circuit breaker of a Service.
+// The breaker opens when either the rate of failed calls or the rate
+// of slow calls over the sliding window reaches its threshold.
+// A call fails if it can not be sent, its first reply is an error
+// or the deadline of its context comes before the reply.
+// The zero fields get the defaults.
+type BreakerPolicy struct {
+	// Window is the period the rates are measured over, 10s by default
+	Window time.Duration
+	// MinRequests is the number of calls in the window
+	// needed to open the breaker, 20 by default
+	MinRequests int
+	// ErrorRate is the threshold of the failed calls, 0.5 by default
+	ErrorRate float64
+	// SlowCall is the latency of the first reply which makes a call slow.
+	// Zero disables the slow calls tracking.
+	SlowCall time.Duration
+	// SlowCallRate is the threshold of the slow calls, 0.5 by default
+	SlowCallRate float64
+	// OpenTimeout is how long the breaker stays open, 5s by default.
+	// A probe call which gets no reply for OpenTimeout fails.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of successful probe calls
+	// which close the breaker, 1 by default
+	HalfOpenRequests int
+}
+
+func (p *BreakerPolicy) withDefaults() {
+	if p.Window == 0 {
+		p.Window = defaultBreakerWindow
+	}
+	if p.MinRequests == 0 {
+		p.MinRequests = defaultBreakerMinRequests
+	}
+	if p.ErrorRate == 0 {
+		p.ErrorRate = defaultBreakerErrorRate
+	}
+	if p.SlowCallRate == 0 {
+		p.SlowCallRate = defaultBreakerErrorRate
+	}
+	if p.OpenTimeout == 0 {
+		p.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if p.HalfOpenRequests == 0 {
+		p.HalfOpenRequests = 1
+	}
+}
+
+func (p *BreakerPolicy) validate() error {
+	switch {
+	case p.Window < minBreakerWindow:
+		return fmt.Errorf("window must be at least %v, got %v", minBreakerWindow, p.Window)
+	case p.MinRequests < 0:
+		return fmt.Errorf("min requests must be positive, got %d", p.MinRequests)
+	case p.ErrorRate <= 0 || p.ErrorRate > 1:
+		return fmt.Errorf("error rate must be in (0, 1], got %v", p.ErrorRate)
+	case p.SlowCall < 0:
+		return fmt.Errorf("slow call duration must not be negative, got %v", p.SlowCall)
+	case p.SlowCallRate <= 0 || p.SlowCallRate > 1:
+		return fmt.Errorf("slow call rate must be in (0, 1], got %v", p.SlowCallRate)
+	case p.OpenTimeout < 0:
+		return fmt.Errorf("open timeout must be positive, got %v", p.OpenTimeout)
+	case p.HalfOpenRequests < 0:
+		return fmt.Errorf("half-open requests must be positive, got %d", p.HalfOpenRequests)
+	}
+	return nil
+}
+
+type breakerBucket struct {
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+type circuitBreaker struct {
+	policy BreakerPolicy
+	state  *stateTracker[BreakerState]
+
+	mu       sync.Mutex
+	buckets  [breakerBuckets]breakerBucket
+	openedAt time.Time
+	// probes in flight and succeeded in the half-open state
+	probes    int
+	recovered int
+}
+
+func newCircuitBreaker(policy BreakerPolicy) *circuitBreaker {
+	return &circuitBreaker{
+		policy: policy,
+		state:  newStateTracker(BreakerClosed),
+	}
+}
+
+// breakerCall reports the outcome of a call let through the breaker
+type breakerCall struct {
+	breaker *circuitBreaker
+	start   time.Time
+	probe   bool
+	once    sync.Once
+}
+
+// allow lets the call through or returns ErrCircuitOpen
+func (b *circuitBreaker) allow() (*breakerCall, error) {
+	b.mu.Lock()
+	state, _ := b.state.get()
+	probe := false
+
+	// the state is updated under the lock to keep the changes in order
+	switch state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			b.mu.Unlock()
+			return nil, ErrCircuitOpen
+		}
+		b.probes, b.recovered = 0, 0
+		state = BreakerHalfOpen
+		fallthrough
+
+	case BreakerHalfOpen:
+		if b.probes+b.recovered >= b.policy.HalfOpenRequests {
+			b.mu.Unlock()
+			return nil, ErrCircuitOpen
+		}
+		b.probes++
+		probe = true
+	}
+	b.state.update(state)
+	b.mu.Unlock()
+
+	b.state.deliver()
+
+	call := &breakerCall{breaker: b, start: time.Now(), probe: probe}
+	if probe {
+		// otherwise a probe nobody waits for holds its slot forever,
+		// it's a no-op if the probe has been settled
+		time.AfterFunc(b.policy.OpenTimeout, func() {
+			call.done(errProbeTimeout)
+		})
+	}
+	return call, nil
+}
+
+// done records the outcome once, the following calls are ignored.
+// A nil call records nothing.
+func (c *breakerCall) done(err error) {
+	if c == nil {
+		return
+	}
+
+	c.once.Do(func() {
+		c.breaker.record(c, err, time.Since(c.start))
+	})
+}
+
+// cancel releases the call without recording it
+func (c *breakerCall) cancel() {
+	c.done(context.Canceled)
+}
+
+func (b *circuitBreaker) record(call *breakerCall, err error, latency time.Duration) {
+	b.mu.Lock()
+	state, _ := b.state.get()
+
+	if err == context.Canceled {
+		// the caller has given up, it tells nothing about the service
+		if call.probe && state == BreakerHalfOpen {
+			b.probes--
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	var (
+		failed = err != nil
+		slow   = b.policy.SlowCall > 0 && latency >= b.policy.SlowCall
+		next   = state
+	)
+
+	switch {
+	case call.probe && state == BreakerHalfOpen:
+		b.probes--
+		if failed || slow {
+			next = BreakerOpen
+			break
+		}
+
+		b.recovered++
+		if b.recovered >= b.policy.HalfOpenRequests {
+			b.buckets = [breakerBuckets]breakerBucket{}
+			next = BreakerClosed
+		}
+
+	case state == BreakerClosed:
+		bucket := b.bucket(time.Now())
+		bucket.total++
+		if failed {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+
+		if b.tripped() {
+			next = BreakerOpen
+		}
+
+	default:
+		// a late call let through before the breaker has opened
+		// tells nothing about the recovery
+		b.mu.Unlock()
+		return
+	}
+
+	if next == BreakerOpen && state != BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	b.state.update(next)
+	b.mu.Unlock()
+
+	b.state.deliver()
+}
+
+// bucket returns the bucket of the moment resetting the stale one
+func (b *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := b.policy.Window / breakerBuckets
+	start := now.Truncate(width)
+
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+func (b *circuitBreaker) tripped() bool {
+	var (
+		since = time.Now().Add(-b.policy.Window)
+		total int
+
+		failures, slow int
+	)
+
+	for _, bucket := range b.buckets {
+		if bucket.start.After(since) {
+			total += bucket.total
+			failures += bucket.failures
+			slow += bucket.slow
+		}
+	}
+
+	if total < b.policy.MinRequests {
+		return false
+	}
+	return float64(failures)/float64(total) >= b.policy.ErrorRate ||
+		float64(slow)/float64(total) >= b.policy.SlowCallRate
+}
+
+// BreakerState returns the state of the circuit breaker,
+// it is always BreakerClosed without WithCircuitBreaker
+func (service *Service) BreakerState() BreakerState {
+	if service.breaker == nil {
+		return BreakerClosed
+	}
+
+	state, _ := service.breaker.state.get()
+	return state
+}
+
+// OnBreakerStateChange adds the handler of the state changes
+// of the circuit breaker. The handlers are called one change at a time
+// in order.
+func (service *Service) OnBreakerStateChange(handler func(from, to BreakerState)) {
+	if service.breaker != nil {
+		service.breaker.state.onChange(handler)
+	}
+}
@@ -0,0 +1,261 @@
+package cocaine12_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	var (
+		mu      sync.Mutex
+		failing = true
+		delay   time.Duration
+	)
+	backend.Handle(cocainetest.PrimitiveMethod("ping"), func(cocainetest.Call) []cocainetest.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		time.Sleep(delay)
+		if failing {
+			return []cocainetest.Reply{cocainetest.Error(1, 1, "failed")}
+		}
+		return []cocainetest.Reply{cocainetest.Value(0)}
+	})
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithCircuitBreaker(cocaine.BreakerPolicy{
+			Window:      time.Minute,
+			MinRequests: 4,
+			SlowCall:    20 * time.Millisecond,
+			OpenTimeout: 50 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	var states []cocaine.BreakerState
+	s.OnBreakerStateChange(func(from, to cocaine.BreakerState) {
+		states = append(states, to)
+	})
+
+	// 2 of 4 calls fail
+	for i := 0; i < 4; i++ {
+		mu.Lock()
+		failing = i%2 == 0
+		mu.Unlock()
+
+		_, err := ping(ctx, s)
+		assert.Equal(t, failing, err != nil)
+	}
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	_, err = s.Call(ctx, "ping")
+	assert.Equal(t, cocaine.ErrCircuitOpen, err)
+
+	// the failed probe opens it again
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	_, err = ping(ctx, s)
+	assert.Error(t, err)
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	// the slow probe as well
+	mu.Lock()
+	failing, delay = false, 20*time.Millisecond
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	_, err = ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	mu.Lock()
+	delay = 0
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	_, err = ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, cocaine.BreakerClosed, s.BreakerState())
+
+	assert.Equal(t, []cocaine.BreakerState{
+		cocaine.BreakerOpen,
+		cocaine.BreakerHalfOpen,
+		cocaine.BreakerOpen,
+		cocaine.BreakerHalfOpen,
+		cocaine.BreakerOpen,
+		cocaine.BreakerHalfOpen,
+		cocaine.BreakerClosed,
+	}, states)
+}
+
+func TestCircuitBreakerProbes(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	var (
+		mu      sync.Mutex
+		failing = true
+	)
+	backend.Handle(cocainetest.PrimitiveMethod("ping"), func(cocainetest.Call) []cocainetest.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return []cocainetest.Reply{cocainetest.Error(1, 1, "failed")}
+		}
+		return []cocainetest.Reply{cocainetest.Value(0)}
+	})
+	// never replies
+	backend.HandleStream(cocainetest.StreamingMethod("hang"), func(cocainetest.Call, func(cocainetest.Reply) error) {})
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithCircuitBreaker(cocaine.BreakerPolicy{
+			Window:      time.Minute,
+			MinRequests: 2,
+			OpenTimeout: 50 * time.Millisecond,
+		}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := ping(ctx, s)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	// the probe without a reply holds its slot until it expires
+	time.Sleep(50 * time.Millisecond)
+	_, err = s.Call(context.Background(), "hang")
+	assert.NoError(t, err)
+	_, err = s.Call(ctx, "ping")
+	assert.Equal(t, cocaine.ErrCircuitOpen, err)
+	assert.Eventually(t, func() bool {
+		return s.BreakerState() == cocaine.BreakerOpen
+	}, time.Second, 5*time.Millisecond)
+
+	// the reply settles the probe even if nobody gets it
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	_, err = s.Call(context.Background(), "ping")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return s.BreakerState() == cocaine.BreakerClosed
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCircuitBreakerLateCall(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	var (
+		mu      sync.Mutex
+		failing = true
+		release = make(chan struct{})
+	)
+	backend.Handle(cocainetest.PrimitiveMethod("ping"), func(cocainetest.Call) []cocainetest.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return []cocainetest.Reply{cocainetest.Error(1, 1, "failed")}
+		}
+		return []cocainetest.Reply{cocainetest.Value(0)}
+	})
+	backend.HandleStream(cocainetest.PrimitiveMethod("slow"), func(_ cocainetest.Call, send func(cocainetest.Reply) error) {
+		<-release
+		send(cocainetest.Error(1, 1, "failed"))
+	})
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const openTimeout = 200 * time.Millisecond
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithCircuitBreaker(cocaine.BreakerPolicy{
+			Window:      time.Minute,
+			MinRequests: 2,
+			OpenTimeout: openTimeout,
+		}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	late, err := s.Call(ctx, "slow")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := ping(ctx, s)
+		assert.Error(t, err)
+	}
+	opened := time.Now()
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	// the late call fails while the breaker is open
+	time.Sleep(openTimeout / 2)
+	close(release)
+	res, err := late.Get(ctx)
+	if assert.NoError(t, err) {
+		assert.Error(t, res.Err())
+	}
+	assert.Equal(t, cocaine.BreakerOpen, s.BreakerState())
+
+	// it neither counts nor keeps the breaker open longer
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	time.Sleep(time.Until(opened.Add(openTimeout + 20*time.Millisecond)))
+	_, err = ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, cocaine.BreakerClosed, s.BreakerState())
+}
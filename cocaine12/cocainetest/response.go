@@ -12,11 +12,14 @@ type Response struct {
 	*bytes.Buffer
 	closed bool
 	Err    *CocaineError
+	// Headers keeps the headers of all frames in order
+	Headers []cocaine12.HeaderField
 }
 
 type CocaineError struct {
-	Msg  string
-	Code int
+	Msg      string
+	Category int
+	Code     int
 }
 
 var _ cocaine12.Response = NewResponse()
@@ -38,19 +41,47 @@ func (r *Response) Close() error {
 	return nil
 }
 
+func (r *Response) CloseWithHeaders(headers ...cocaine12.HeaderField) error {
+	if r.closed {
+		return syscall.EINVAL
+	}
+
+	r.Headers = append(r.Headers, headers...)
+	return r.Close()
+}
+
 func (r *Response) ZeroCopyWrite(data []byte) error {
 	_, err := r.Buffer.Write(data)
 	return err
 }
 
+func (r *Response) ZeroCopyWriteWithHeaders(data []byte, headers ...cocaine12.HeaderField) error {
+	r.Headers = append(r.Headers, headers...)
+	return r.ZeroCopyWrite(data)
+}
+
+func (r *Response) ErrorMsgWithHeaders(code int, msg string, headers ...cocaine12.HeaderField) error {
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+
+	r.Headers = append(r.Headers, headers...)
+	return r.ErrorMsg(code, msg)
+}
+
 func (r *Response) ErrorMsg(code int, msg string) error {
+	return r.ErrorWithCategory(cocaine12.ErrorCategoryFramework, code, msg)
+}
+
+func (r *Response) ErrorWithCategory(category, code int, msg string) error {
 	if r.closed {
 		return io.ErrClosedPipe
 	}
 
 	r.Err = &CocaineError{
-		Msg:  msg,
-		Code: code,
+		Msg:      msg,
+		Category: category,
+		Code:     code,
 	}
 	return r.Close()
 }
@@ -0,0 +1,509 @@
+package cocainetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/ugorji/go/codec"
+)
+
+// message types of the protocol v1
+const (
+	utilitySession = 1
+
+	handshakeType = 0
+	heartbeatType = 0
+	terminateType = 1
+
+	invokeType = 0
+	chunkType  = 0
+	errorType  = 1
+	chokeType  = 2
+)
+
+var (
+	mhRuntime = codec.MsgpackHandle{
+		BasicHandle: codec.BasicHandle{
+			EncodeOptions: codec.EncodeOptions{
+				StructToArray: true,
+			},
+		},
+	}
+	hRuntime = &mhRuntime
+)
+
+var (
+	// ErrRuntimeClosed means that the runtime has been closed
+	ErrRuntimeClosed = errors.New("the runtime is closed")
+	// ErrWorkerDisconnected means that the worker has closed the connection
+	ErrWorkerDisconnected = errors.New("the worker has disconnected")
+)
+
+// Runtime is a fake cocaine-runtime speaking the worker protocol v1
+// over a temporary unix socket. It serves a single worker.
+//
+//	rt, _ := cocainetest.NewRuntime()
+//	defer rt.Close()
+//	w, _ := cocaine.NewWorker(rt.WorkerOptions()...)
+//	go w.Run(handlers)
+//	session, _ := rt.Invoke(ctx, "ping", []byte("PING"))
+//	data, _ := session.ReadAll(ctx)
+type Runtime struct {
+	dir      string
+	endpoint string
+	uuid     string
+	listener net.Listener
+
+	// closed once the handshake is received
+	handshaked chan struct{}
+	// closed once the worker disconnects
+	disconnected chan struct{}
+	// closed by Close
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// serializes the frames written to the worker. It's separate from mu,
+	// as a write blocks while the worker doesn't read the socket.
+	writeMu sync.Mutex
+	encoder *codec.Encoder
+
+	mu                sync.Mutex
+	conn              net.Conn
+	workerID          string
+	withholdHeartbeat bool
+	heartbeats        int
+	nextSession       uint64
+	sessions          map[uint64]*Session
+	terminated        chan struct{}
+}
+
+// NewRuntime starts listening on a unix socket in a temporary directory
+func NewRuntime() (*Runtime, error) {
+	dir, err := os.MkdirTemp("", "cocainetest")
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := filepath.Join(dir, "runtime.sock")
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	r := &Runtime{
+		dir:      dir,
+		endpoint: endpoint,
+		uuid:     fmt.Sprintf("cocainetest-%d", os.Getpid()),
+		listener: listener,
+
+		handshaked:   make(chan struct{}),
+		disconnected: make(chan struct{}),
+		closed:       make(chan struct{}),
+		terminated:   make(chan struct{}),
+
+		nextSession: utilitySession,
+		sessions:    make(map[uint64]*Session),
+	}
+
+	go r.accept()
+	return r, nil
+}
+
+// Endpoint returns the path to the unix socket
+func (r *Runtime) Endpoint() string {
+	return r.endpoint
+}
+
+// WorkerOptions returns options to connect a worker to the runtime
+func (r *Runtime) WorkerOptions() []cocaine.WorkerOption {
+	return []cocaine.WorkerOption{
+		cocaine.WithEndpoint(r.endpoint),
+		cocaine.WithUUID(r.uuid),
+		cocaine.WithProtocolVersion(1),
+	}
+}
+
+// Handshake waits for the worker to introduce itself and returns its id
+func (r *Runtime) Handshake(ctx context.Context) (string, error) {
+	select {
+	case <-r.handshaked:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.workerID, nil
+	case <-r.closed:
+		return "", ErrRuntimeClosed
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// WithholdHeartbeats stops replying to heartbeats if withhold is true,
+// so the worker sees itself disowned after the disown timeout
+func (r *Runtime) WithholdHeartbeats(withhold bool) {
+	r.mu.Lock()
+	r.withholdHeartbeat = withhold
+	r.mu.Unlock()
+}
+
+// Heartbeats returns the number of heartbeats received from the worker
+func (r *Runtime) Heartbeats() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.heartbeats
+}
+
+// Disconnected returns a channel which is closed once the worker disconnects
+func (r *Runtime) Disconnected() <-chan struct{} {
+	return r.disconnected
+}
+
+// Open starts a session of the event without sending any chunks
+func (r *Runtime) Open(ctx context.Context, event string) (*Session, error) {
+	if _, err := r.Handshake(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.nextSession++
+	session := newSession(r, r.nextSession)
+	r.sessions[session.id] = session
+	r.mu.Unlock()
+
+	if err := r.send(session.id, invokeType, []interface{}{event}); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Invoke starts a session of the event, sends the chunks and closes
+// the request stream
+func (r *Runtime) Invoke(ctx context.Context, event string, chunks ...[]byte) (*Session, error) {
+	session, err := r.Open(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		if err := session.Write(chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := session.Close(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Terminate asks the worker to terminate and waits for its reply
+// or the disconnection, as the worker may close the connection
+// before its reply is flushed
+func (r *Runtime) Terminate(ctx context.Context, code int, reason string) error {
+	if _, err := r.Handshake(ctx); err != nil {
+		return err
+	}
+
+	if err := r.send(utilitySession, terminateType, []interface{}{code, reason}); err != nil {
+		return err
+	}
+
+	select {
+	case <-r.terminated:
+		return nil
+	case <-r.disconnected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close disconnects the worker and removes the socket
+func (r *Runtime) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		r.listener.Close()
+
+		r.mu.Lock()
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		r.mu.Unlock()
+
+		os.RemoveAll(r.dir)
+	})
+	return nil
+}
+
+func (r *Runtime) accept() {
+	conn, err := r.listener.Accept()
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	select {
+	case <-r.closed:
+		r.mu.Unlock()
+		conn.Close()
+		return
+	default:
+	}
+	r.conn = conn
+	r.mu.Unlock()
+
+	r.writeMu.Lock()
+	r.encoder = codec.NewEncoder(conn, hRuntime)
+	r.writeMu.Unlock()
+
+	r.readLoop(conn)
+}
+
+func (r *Runtime) readLoop(conn net.Conn) {
+	defer close(r.disconnected)
+	defer r.closeSessions()
+
+	decoder := codec.NewDecoder(bufio.NewReader(conn), hRuntime)
+	for {
+		var msg cocaine.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+
+		if msg.Session == utilitySession {
+			r.onUtility(&msg)
+			continue
+		}
+
+		r.mu.Lock()
+		session, ok := r.sessions[msg.Session]
+		r.mu.Unlock()
+		if ok {
+			session.push(&msg)
+		}
+	}
+}
+
+func (r *Runtime) onUtility(msg *cocaine.Message) {
+	select {
+	case <-r.handshaked:
+	default:
+		// the first message is the handshake
+		if msg.MsgType == handshakeType && len(msg.Payload) > 0 {
+			r.mu.Lock()
+			r.workerID = fmt.Sprintf("%s", msg.Payload[0])
+			r.mu.Unlock()
+			close(r.handshaked)
+		}
+		return
+	}
+
+	switch msg.MsgType {
+	case heartbeatType:
+		r.mu.Lock()
+		r.heartbeats++
+		withhold := r.withholdHeartbeat
+		r.mu.Unlock()
+
+		if !withhold {
+			r.send(utilitySession, heartbeatType, []interface{}{})
+		}
+	case terminateType:
+		r.mu.Lock()
+		select {
+		case <-r.terminated:
+		default:
+			close(r.terminated)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *Runtime) closeSessions() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, session := range r.sessions {
+		session.disconnect()
+		delete(r.sessions, id)
+	}
+}
+
+func (r *Runtime) send(session, msgType uint64, payload []interface{}) error {
+	select {
+	case <-r.closed:
+		return ErrRuntimeClosed
+	case <-r.disconnected:
+		return ErrWorkerDisconnected
+	default:
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	return r.encoder.Encode(&cocaine.Message{
+		CommonMessageInfo: cocaine.CommonMessageInfo{
+			Session: session,
+			MsgType: msgType,
+		},
+		Payload: payload,
+		Headers: cocaine.CocaineHeaders{},
+	})
+}
+
+// Session is a session opened by the runtime
+type Session struct {
+	runtime *Runtime
+	id      uint64
+
+	mu     sync.Mutex
+	frames []*cocaine.Message
+	done   bool
+	// signals about new frames
+	notify chan struct{}
+}
+
+func newSession(r *Runtime, id uint64) *Session {
+	return &Session{
+		runtime: r,
+		id:      id,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// ID returns the session id
+func (s *Session) ID() uint64 {
+	return s.id
+}
+
+// Write sends a chunk to the handler
+func (s *Session) Write(chunk []byte) error {
+	return s.runtime.send(s.id, chunkType, []interface{}{chunk})
+}
+
+// Error sends an error to the handler
+func (s *Session) Error(category, code int, message string) error {
+	return s.runtime.send(s.id, errorType, []interface{}{[2]int{category, code}, message})
+}
+
+// Close closes the request stream
+func (s *Session) Close() error {
+	return s.runtime.send(s.id, chokeType, []interface{}{})
+}
+
+// Read returns the next chunk sent by the handler. It returns io.EOF
+// once the response is closed and *cocaine.ErrRequest if the handler
+// has replied with an error.
+func (s *Session) Read(ctx context.Context) ([]byte, error) {
+	msg, err := s.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg.MsgType {
+	case chunkType:
+		if len(msg.Payload) == 0 {
+			return nil, cocaine.ErrBadPayload
+		}
+		switch chunk := msg.Payload[0].(type) {
+		case []byte:
+			return chunk, nil
+		case string:
+			return []byte(chunk), nil
+		}
+		return nil, cocaine.ErrBadPayload
+	case errorType:
+		return nil, decodeError(msg)
+	default:
+		return nil, io.EOF
+	}
+}
+
+// ReadAll reads the chunks until the response is closed
+func (s *Session) ReadAll(ctx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		chunk, err := s.Read(ctx)
+		switch err {
+		case nil:
+			buf.Write(chunk)
+		case io.EOF:
+			return buf.Bytes(), nil
+		default:
+			return buf.Bytes(), err
+		}
+	}
+}
+
+func (s *Session) next(ctx context.Context) (*cocaine.Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.frames) > 0 {
+			msg := s.frames[0]
+			s.frames = s.frames[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		done := s.done
+		s.mu.Unlock()
+
+		if done {
+			return nil, ErrWorkerDisconnected
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *Session) push(msg *cocaine.Message) {
+	s.mu.Lock()
+	s.frames = append(s.frames, msg)
+	s.mu.Unlock()
+	s.wakeup()
+}
+
+func (s *Session) disconnect() {
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+	s.wakeup()
+}
+
+func (s *Session) wakeup() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func decodeError(msg *cocaine.Message) error {
+	var perr struct {
+		CodeInfo [2]int
+		Message  string
+	}
+
+	var buf []byte
+	if err := codec.NewEncoderBytes(&buf, hRuntime).Encode(msg.Payload); err != nil {
+		return err
+	}
+	if err := codec.NewDecoderBytes(buf, hRuntime).Decode(&perr); err != nil {
+		return err
+	}
+
+	return &cocaine.ErrRequest{
+		Message:  perr.Message,
+		Category: perr.CodeInfo[0],
+		Code:     perr.CodeInfo[1],
+	}
+}
@@ -0,0 +1,189 @@
+package cocainetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/stretchr/testify/assert"
+)
+
+func startWorker(t *testing.T, rt *Runtime, opts ...cocaine.WorkerOption) (*cocaine.Worker, <-chan error) {
+	w, err := cocaine.NewWorker(append(rt.WorkerOptions(), opts...)...)
+	if err != nil {
+		t.Fatalf("unable to create worker: %v", err)
+	}
+
+	w.On("echo", func(ctx context.Context, req cocaine.Request, res cocaine.Response) {
+		for {
+			chunk, err := req.Read(ctx)
+			if err != nil {
+				break
+			}
+			res.Write(chunk)
+		}
+		res.Close()
+	})
+	w.On("fail", func(ctx context.Context, req cocaine.Request, res cocaine.Response) {
+		res.ErrorWithCategory(cocaine.ErrorCategoryApplication, 10, "failed")
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(nil)
+	}()
+	return w, done
+}
+
+func TestRuntimeInvoke(t *testing.T) {
+	rt, err := NewRuntime()
+	if err != nil {
+		t.Fatalf("unable to start runtime: %v", err)
+	}
+	defer rt.Close()
+
+	w, _ := startWorker(t, rt)
+	defer w.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id, err := rt.Handshake(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, rt.uuid, id)
+
+	first, err := rt.Invoke(ctx, "echo", []byte("A"), []byte("B"))
+	assert.NoError(t, err)
+	second, err := rt.Invoke(ctx, "echo", []byte("C"))
+	assert.NoError(t, err)
+
+	data, err := second.ReadAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("C"), data)
+
+	data, err = first.ReadAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("AB"), data)
+
+	failed, err := rt.Invoke(ctx, "fail")
+	assert.NoError(t, err)
+	_, err = failed.Read(ctx)
+	assert.ErrorIs(t, err, cocaine.ErrApplicationError)
+
+	missing, err := rt.Invoke(ctx, "missing")
+	assert.NoError(t, err)
+	_, err = missing.Read(ctx)
+	assert.ErrorIs(t, err, &cocaine.ErrRequest{
+		Category: cocaine.ErrorCategoryFramework,
+		Code:     cocaine.ErrorNoEventHandler,
+	})
+}
+
+func TestRuntimeTerminate(t *testing.T) {
+	rt, err := NewRuntime()
+	if err != nil {
+		t.Fatalf("unable to start runtime: %v", err)
+	}
+	defer rt.Close()
+
+	_, done := startWorker(t, rt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, rt.Terminate(ctx, 1, "shutdown"))
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("the worker has not stopped after termination")
+	}
+}
+
+func TestRuntimeDisown(t *testing.T) {
+	rt, err := NewRuntime()
+	if err != nil {
+		t.Fatalf("unable to start runtime: %v", err)
+	}
+	defer rt.Close()
+
+	rt.WithholdHeartbeats(true)
+	_, done := startWorker(t, rt,
+		cocaine.WithHeartbeatTimeout(50*time.Millisecond),
+		cocaine.WithDisownTimeout(20*time.Millisecond),
+	)
+
+	select {
+	case err := <-done:
+		assert.Equal(t, cocaine.ErrDisowned, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker has not been disowned")
+	}
+
+	select {
+	case <-rt.Disconnected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker has not disconnected")
+	}
+	assert.Equal(t, 1, rt.Heartbeats())
+}
+
+func TestRuntimeBlockedWrite(t *testing.T) {
+	rt, err := NewRuntime()
+	if err != nil {
+		t.Fatalf("unable to start runtime: %v", err)
+	}
+	defer rt.Close()
+
+	w, _ := startWorker(t, rt, cocaine.WithWatermarks(cocaine.Watermarks{
+		HighMessages: 1,
+		LowMessages:  1,
+	}))
+	defer w.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	w.On("stuck", func(ctx context.Context, req cocaine.Request, res cocaine.Response) {
+		<-release
+		res.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := rt.Open(ctx, "stuck")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the worker parks the session and stops reading the socket,
+	// so the writes block once the socket buffer is full
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		chunk := make([]byte, 64<<10)
+		for i := 0; i < 256; i++ {
+			if session.Write(chunk) != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("the writes have not blocked")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	state := make(chan int)
+	go func() {
+		state <- rt.Heartbeats()
+	}()
+
+	select {
+	case <-state:
+	case <-ctx.Done():
+		t.Fatal("a blocked write holds the runtime state")
+	}
+}
@@ -0,0 +1,535 @@
+package cocainetest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/ugorji/go/codec"
+)
+
+// Protocol describes a stream of a service method: message ids map
+// to message names and the protocol which follows the message.
+// A nil Protocol means that the stream keeps its protocol,
+// an empty one means the end of the stream.
+type Protocol map[uint64]ProtocolItem
+
+// ProtocolItem is a message of a Protocol
+type ProtocolItem struct {
+	Name string
+	Next Protocol
+}
+
+var (
+	// PrimitiveProtocol replies with a single value or error
+	PrimitiveProtocol = Protocol{
+		0: {"value", Protocol{}},
+		1: {"error", Protocol{}},
+	}
+	// StreamingProtocol replies with a stream of chunks
+	// finished by an error or close
+	StreamingProtocol = Protocol{
+		0: {"write", nil},
+		1: {"error", Protocol{}},
+		2: {"close", Protocol{}},
+	}
+)
+
+// Method describes a method of a service.
+// Downstream is the protocol of the client messages after the invocation,
+// Upstream is the protocol of the replies.
+type Method struct {
+	Name       string
+	Downstream Protocol
+	Upstream   Protocol
+}
+
+// PrimitiveMethod returns a method replying with a single value or error
+func PrimitiveMethod(name string) Method {
+	return Method{Name: name, Downstream: Protocol{}, Upstream: PrimitiveProtocol}
+}
+
+// StreamingMethod returns a method replying with a stream of chunks
+func StreamingMethod(name string) Method {
+	return Method{Name: name, Downstream: Protocol{}, Upstream: StreamingProtocol}
+}
+
+// Reply is a message sent by a service in reply to a call
+type Reply struct {
+	Type uint64
+	Args []interface{}
+}
+
+// Value replies with a value of PrimitiveProtocol
+func Value(args ...interface{}) Reply {
+	return Reply{Type: 0, Args: args}
+}
+
+// Chunk replies with a chunk of StreamingProtocol
+func Chunk(args ...interface{}) Reply {
+	return Reply{Type: 0, Args: args}
+}
+
+// Error replies with an error, it has the same id in both protocols
+func Error(category, code int, message string) Reply {
+	return Reply{Type: 1, Args: []interface{}{[2]int{category, code}, message}}
+}
+
+// Close finishes a stream of StreamingProtocol
+func Close() Reply {
+	return Reply{Type: 2, Args: []interface{}{}}
+}
+
+// Call is an invocation of a service method
+type Call struct {
+	Method  string
+	Args    []interface{}
+	Headers []cocaine.HeaderField
+}
+
+// Handler makes the replies to a call
+type Handler func(call Call) []Reply
+
+// StreamHandler replies to a call with send. It runs in its own
+// goroutine, so it can keep the stream open as long as needed.
+// send fails once the client has disconnected.
+type StreamHandler func(call Call, send func(Reply) error)
+
+type methodHandler struct {
+	handler       Handler
+	streamHandler StreamHandler
+}
+
+// Service is a scripted cocaine service listening on a local tcp port.
+// Register methods before the service is resolved, as the dispatch map
+// is sent to clients by the locator.
+type Service struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	methods  []Method
+	handlers []methodHandler
+	calls    []Call
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewService starts a service without methods on a free local port
+func NewService() (*Service, error) {
+	return NewServiceAt("127.0.0.1:0")
+}
+
+// NewServiceAt starts a service without methods on the address.
+// It allows to bring a closed service back on the same endpoint.
+func NewServiceAt(address string) (*Service, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Handle adds the method answered by the handler.
+// Methods get ids in the order they are added.
+func (s *Service) Handle(method Method, handler Handler) {
+	s.addMethod(method, methodHandler{handler: handler})
+}
+
+// HandleStream adds the method answered by the stream handler
+func (s *Service) HandleStream(method Method, handler StreamHandler) {
+	s.addMethod(method, methodHandler{streamHandler: handler})
+}
+
+func (s *Service) addMethod(method Method, handler methodHandler) {
+	s.mu.Lock()
+	s.methods = append(s.methods, method)
+	s.handlers = append(s.handlers, handler)
+	s.mu.Unlock()
+}
+
+// Script adds the method which answers every call with the replies
+func (s *Service) Script(method Method, replies ...Reply) {
+	s.Handle(method, func(Call) []Reply {
+		return replies
+	})
+}
+
+// Calls returns the calls received so far
+func (s *Service) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Endpoint returns the address the service listens on
+func (s *Service) Endpoint() cocaine.EndpointItem {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return cocaine.EndpointItem{
+		IP:   addr.IP.String(),
+		Port: uint64(addr.Port),
+	}
+}
+
+// Info returns the description of the service for the locator
+func (s *Service) Info() ServiceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ServiceInfo{
+		Endpoints: []cocaine.EndpointItem{s.Endpoint()},
+		Version:   1,
+		API:       append([]Method(nil), s.methods...),
+	}
+}
+
+// Close stops the service and drops its connections
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return s.listener.Close()
+}
+
+func (s *Service) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *Service) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	var (
+		decoder = codec.NewDecoder(bufio.NewReader(conn), hRuntime)
+		encoder = codec.NewEncoder(conn, hRuntime)
+		// stream handlers write concurrently
+		encoderMu sync.Mutex
+		// sessions opened by the client
+		sessions = make(map[uint64]struct{})
+	)
+
+	send := func(session uint64, reply Reply) error {
+		encoderMu.Lock()
+		defer encoderMu.Unlock()
+		return encoder.Encode(&cocaine.Message{
+			CommonMessageInfo: cocaine.CommonMessageInfo{
+				Session: session,
+				MsgType: reply.Type,
+			},
+			Payload: reply.Args,
+			Headers: cocaine.CocaineHeaders{},
+		})
+	}
+
+	for {
+		var msg cocaine.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return
+		}
+
+		// the following messages of a session belong
+		// to the downstream protocol, they are not scripted
+		if _, ok := sessions[msg.Session]; ok {
+			continue
+		}
+		sessions[msg.Session] = struct{}{}
+
+		s.mu.Lock()
+		if msg.MsgType >= uint64(len(s.methods)) {
+			s.mu.Unlock()
+			return
+		}
+		call := Call{
+			Method:  s.methods[msg.MsgType].Name,
+			Args:    msg.Payload,
+			Headers: msg.Headers.Fields(),
+		}
+		handler := s.handlers[msg.MsgType]
+		s.calls = append(s.calls, call)
+		s.mu.Unlock()
+
+		session := msg.Session
+		if handler.streamHandler != nil {
+			go handler.streamHandler(call, func(reply Reply) error {
+				return send(session, reply)
+			})
+			continue
+		}
+
+		for _, reply := range handler.handler(call) {
+			if err := send(session, reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServiceInfo is the reply of the locator to resolve
+type ServiceInfo struct {
+	Endpoints []cocaine.EndpointItem
+	Version   uint64
+	// API lists the methods, their ids are the indexes
+	API []Method
+}
+
+func (i ServiceInfo) encode() []interface{} {
+	var endpoints = make([]interface{}, 0, len(i.Endpoints))
+	for _, endpoint := range i.Endpoints {
+		endpoints = append(endpoints, []interface{}{endpoint.IP, endpoint.Port})
+	}
+
+	var api = make(map[uint64]interface{}, len(i.API))
+	for id, method := range i.API {
+		api[uint64(id)] = []interface{}{
+			method.Name,
+			method.Downstream.encode(),
+			method.Upstream.encode(),
+		}
+	}
+
+	return []interface{}{endpoints, i.Version, api}
+}
+
+func (p Protocol) encode() interface{} {
+	if p == nil {
+		return nil
+	}
+
+	var items = make(map[uint64]interface{}, len(p))
+	for id, item := range p {
+		items[id] = []interface{}{item.Name, item.Next.encode()}
+	}
+	return items
+}
+
+// Locator is a fake locator answering resolve with the registered services.
+// Its cluster, routing groups and the services announced by other nodes
+// are set by the test and streamed to the watchers.
+type Locator struct {
+	*Service
+
+	mu       sync.Mutex
+	services map[string]ServiceInfo
+	cluster  map[string][]cocaine.EndpointItem
+	nodes    map[string]map[string]ServiceInfo
+	groups   map[string][]cocaine.RingPoint
+	delay    time.Duration
+
+	nodeWatchers    []func(Reply) error
+	routingWatchers []func(Reply) error
+}
+
+// NewLocator starts a locator without services
+func NewLocator() (*Locator, error) {
+	service, err := NewService()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Locator{
+		Service:  service,
+		services: make(map[string]ServiceInfo),
+		cluster:  make(map[string][]cocaine.EndpointItem),
+		nodes:    make(map[string]map[string]ServiceInfo),
+		groups:   make(map[string][]cocaine.RingPoint),
+	}
+	service.Handle(PrimitiveMethod("resolve"), l.resolve)
+	service.HandleStream(StreamingMethod("connect"), l.connect)
+	service.Script(PrimitiveMethod("refresh"), Value())
+	service.Handle(PrimitiveMethod("cluster"), l.clusterNodes)
+	service.Script(PrimitiveMethod("publish"), Error(1, 1, "publish is not supported"))
+	service.HandleStream(StreamingMethod("routing"), l.routing)
+	return l, nil
+}
+
+// Register makes the locator resolve the name with the info
+func (l *Locator) Register(name string, info ServiceInfo) {
+	l.mu.Lock()
+	l.services[name] = info
+	l.mu.Unlock()
+}
+
+// Add registers the service under the name
+func (l *Locator) Add(name string, service *Service) {
+	l.Register(name, service.Info())
+}
+
+// Unregister makes the locator reply with an error to resolve of the name
+func (l *Locator) Unregister(name string) {
+	l.mu.Lock()
+	delete(l.services, name)
+	l.mu.Unlock()
+}
+
+// SetResolveDelay makes the locator wait before it replies to resolve
+func (l *Locator) SetResolveDelay(delay time.Duration) {
+	l.mu.Lock()
+	l.delay = delay
+	l.mu.Unlock()
+}
+
+// SetCluster sets the reply to cluster
+func (l *Locator) SetCluster(nodes map[string][]cocaine.EndpointItem) {
+	l.mu.Lock()
+	l.cluster = nodes
+	l.mu.Unlock()
+}
+
+// Announce sends the services of the node to the watchers.
+// Empty services mean that the node has left the cluster.
+func (l *Locator) Announce(node string, services map[string]ServiceInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(services) == 0 {
+		delete(l.nodes, node)
+	} else {
+		l.nodes[node] = services
+	}
+	l.nodeWatchers = broadcast(l.nodeWatchers, nodeChunk(node, services))
+}
+
+// SetRouting replaces the routing groups and sends them to the watchers
+func (l *Locator) SetRouting(groups map[string][]cocaine.RingPoint) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.groups = groups
+	l.routingWatchers = broadcast(l.routingWatchers, routingChunk(groups))
+}
+
+// Endpoints returns the endpoints to pass to cocaine.NewService
+// and cocaine.NewLocator
+func (l *Locator) Endpoints() []string {
+	endpoint := l.Endpoint()
+	return []string{net.JoinHostPort(endpoint.IP, strconv.FormatUint(endpoint.Port, 10))}
+}
+
+func (l *Locator) resolve(call Call) []Reply {
+	var name string
+	if len(call.Args) > 0 {
+		switch n := call.Args[0].(type) {
+		case []byte:
+			name = string(n)
+		case string:
+			name = n
+		}
+	}
+
+	l.mu.Lock()
+	info, ok := l.services[name]
+	delay := l.delay
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+
+	if !ok {
+		return []Reply{Error(1, 1, fmt.Sprintf("service '%s' is not available", name))}
+	}
+	return []Reply{Value(info.encode()...)}
+}
+
+func (l *Locator) clusterNodes(Call) []Reply {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var nodes = make(map[string]interface{}, len(l.cluster))
+	for uuid, endpoints := range l.cluster {
+		var items = make([]interface{}, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			items = append(items, []interface{}{endpoint.IP, endpoint.Port})
+		}
+		nodes[uuid] = items
+	}
+	return []Reply{Value(nodes)}
+}
+
+// connect sends the known nodes, then keeps the stream open for Announce
+func (l *Locator) connect(call Call, send func(Reply) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for node, services := range l.nodes {
+		if err := send(nodeChunk(node, services)); err != nil {
+			return
+		}
+	}
+	l.nodeWatchers = append(l.nodeWatchers, send)
+}
+
+// routing sends the current groups, then keeps the stream open for SetRouting
+func (l *Locator) routing(call Call, send func(Reply) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := send(routingChunk(l.groups)); err != nil {
+		return
+	}
+	l.routingWatchers = append(l.routingWatchers, send)
+}
+
+func nodeChunk(node string, services map[string]ServiceInfo) Reply {
+	var encoded = make(map[string]interface{}, len(services))
+	for name, info := range services {
+		encoded[name] = info.encode()
+	}
+	return Chunk(node, encoded)
+}
+
+func routingChunk(groups map[string][]cocaine.RingPoint) Reply {
+	var encoded = make(map[string]interface{}, len(groups))
+	for name, ring := range groups {
+		var points = make([]interface{}, 0, len(ring))
+		for _, point := range ring {
+			points = append(points, []interface{}{point.Point, point.Service})
+		}
+		encoded[name] = points
+	}
+	return Chunk(encoded)
+}
+
+// broadcast sends the reply to the watchers and drops the disconnected ones
+func broadcast(watchers []func(Reply) error, reply Reply) []func(Reply) error {
+	var alive = watchers[:0]
+	for _, send := range watchers {
+		if send(reply) == nil {
+			alive = append(alive, send)
+		}
+	}
+	return alive
+}
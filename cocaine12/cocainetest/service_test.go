@@ -0,0 +1,82 @@
+package cocainetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeLocatorAndService(t *testing.T) {
+	loc, err := NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	storage, err := NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer storage.Close()
+
+	storage.Script(PrimitiveMethod("read"), Value("data"))
+	storage.Script(StreamingMethod("find"), Chunk("A"), Chunk("B"), Close())
+	storage.Script(PrimitiveMethod("remove"), Error(1, 2, "denied"))
+	loc.Add("storage", storage)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service, err := cocaine.NewService(ctx, "storage", loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer service.Close()
+
+	ch, err := service.Call(ctx, "read", "collection", "key")
+	if assert.NoError(t, err) {
+		res, err := ch.Get(ctx)
+		assert.NoError(t, err)
+		var value string
+		assert.NoError(t, res.ExtractTuple(&value))
+		assert.Equal(t, "data", value)
+		assert.True(t, ch.Closed())
+	}
+
+	ch, err = service.Call(ctx, "find", "collection")
+	if assert.NoError(t, err) {
+		var chunks []string
+		for !ch.Closed() {
+			res, err := ch.Get(ctx)
+			if !assert.NoError(t, err) {
+				break
+			}
+			if method, _, _ := res.Result(); method != 0 {
+				continue
+			}
+			var chunk string
+			assert.NoError(t, res.ExtractTuple(&chunk))
+			chunks = append(chunks, chunk)
+		}
+		assert.Equal(t, []string{"A", "B"}, chunks)
+	}
+
+	ch, err = service.Call(ctx, "remove", "collection", "key")
+	if assert.NoError(t, err) {
+		res, err := ch.Get(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, &cocaine.ErrRequest{Message: "denied", Category: 1, Code: 2}, res.Err())
+	}
+
+	calls := storage.Calls()
+	if assert.Len(t, calls, 3) {
+		assert.Equal(t, "read", calls[0].Method)
+		assert.Len(t, calls[0].Args, 2)
+	}
+
+	_, err = cocaine.NewService(ctx, "missing", loc.Endpoints())
+	assert.Error(t, err)
+}
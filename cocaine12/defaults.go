@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -14,6 +15,11 @@ const (
 	defaultLocatorEndpoint = "localhost:10053"
 	tokenTypeKey           = "COCAINE_APP_TOKEN_TYPE"
 	tokenBodyKey           = "COCAINE_APP_TOKEN_BODY"
+
+	heartbeatTimeoutKey      = "COCAINE_WORKER_HEARTBEAT_TIMEOUT"
+	disownTimeoutKey         = "COCAINE_WORKER_DISOWN_TIMEOUT"
+	coreConnectionTimeoutKey = "COCAINE_WORKER_CONNECTION_TIMEOUT"
+	terminationTimeoutKey    = "COCAINE_WORKER_TERMINATION_TIMEOUT"
 )
 
 type defaultValues struct {
@@ -24,6 +30,7 @@ type defaultValues struct {
 	uuid     string
 	debug    bool
 	token    Token
+	timeouts WorkerTimeouts
 }
 
 func (d *defaultValues) ApplicationName() string {
@@ -60,6 +67,10 @@ func (d *defaultValues) Token() Token {
 	return d.token
 }
 
+func (d *defaultValues) WorkerTimeouts() WorkerTimeouts {
+	return d.timeouts
+}
+
 // DefaultValues provides an interface to read
 // various information provided by Cocaine-Runtime to the worker
 type DefaultValues interface {
@@ -73,6 +84,12 @@ type DefaultValues interface {
 	Token() Token
 }
 
+// WorkerTimeoutsProvider is implemented by DefaultValues
+// which provide the worker timeouts, e.g. the ones of GetDefaults
+type WorkerTimeoutsProvider interface {
+	WorkerTimeouts() WorkerTimeouts
+}
+
 var (
 	initDefaultValues sync.Once
 	storedDefaults    DefaultValues
@@ -109,6 +126,23 @@ func parseLocators(arg string) []string {
 	return strings.Split(arg, ",")
 }
 
+// durationFromEnv returns the duration stored in the environment variable
+// or the fallback if the variable is not set or malformed.
+// A malformed value is reported to stderr.
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s, %v is used: %v\n", key, fallback, err)
+		return fallback
+	}
+	return d
+}
+
 func newDefaults(args []string, setname string) *defaultValues {
 	var (
 		values = new(defaultValues)
@@ -118,6 +152,12 @@ func newDefaults(args []string, setname string) *defaultValues {
 
 	values.locators = []string{defaultLocatorEndpoint}
 	values.debug = strings.ToUpper(os.Getenv("DEBUG")) == "DEBUG"
+	values.timeouts = WorkerTimeouts{
+		Heartbeat:      durationFromEnv(heartbeatTimeoutKey, heartbeatTimeout),
+		Disown:         durationFromEnv(disownTimeoutKey, disownTimeout),
+		CoreConnection: durationFromEnv(coreConnectionTimeoutKey, coreConnectionTimeout),
+		Termination:    durationFromEnv(terminationTimeoutKey, terminationTimeout),
+	}
 
 	flagSet := flag.NewFlagSet(setname, flag.ContinueOnError)
 	flagSet.SetOutput(ioutil.Discard)
@@ -127,6 +167,10 @@ func newDefaults(args []string, setname string) *defaultValues {
 	flagSet.IntVar(&values.protocol, "protocol", defaultProtocolVersion, "protocol version")
 	flagSet.StringVar(&values.uuid, "uuid", "", "UUID")
 	flagSet.BoolVar(&showVersion, "showcocaineversion", false, "print framework version")
+	flagSet.DurationVar(&values.timeouts.Heartbeat, "heartbeat-timeout", values.timeouts.Heartbeat, "interval between heartbeats")
+	flagSet.DurationVar(&values.timeouts.Disown, "disown-timeout", values.timeouts.Disown, "time to wait for a heartbeat reply")
+	flagSet.DurationVar(&values.timeouts.CoreConnection, "connection-timeout", values.timeouts.CoreConnection, "timeout to connect to the Cocaine")
+	flagSet.DurationVar(&values.timeouts.Termination, "termination-timeout", values.timeouts.Termination, "time given to the termination handler")
 	flagSet.Parse(args)
 
 	values.token = Token{os.Getenv(tokenTypeKey), os.Getenv(tokenBodyKey)}
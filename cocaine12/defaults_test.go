@@ -1,8 +1,10 @@
 package cocaine12
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -44,3 +46,38 @@ func TestParseToken(t *testing.T) {
 	assert.Equal(t, "TVM", def.Token().Type(), "invalid token type")
 	assert.Equal(t, "very_secret", def.Token().Body(), "invalid token body")
 }
+
+func TestParseWorkerTimeouts(t *testing.T) {
+	def := newDefaults([]string{}, "test")
+	assert.Equal(t, DefaultWorkerTimeouts(), def.WorkerTimeouts(), "invalid default timeouts")
+	_, ok := GetDefaults().(WorkerTimeoutsProvider)
+	assert.True(t, ok, "the defaults don't provide the timeouts")
+
+	os.Setenv("COCAINE_WORKER_HEARTBEAT_TIMEOUT", "30s")
+	os.Setenv("COCAINE_WORKER_DISOWN_TIMEOUT", "malformed")
+	defer os.Unsetenv("COCAINE_WORKER_HEARTBEAT_TIMEOUT")
+	defer os.Unsetenv("COCAINE_WORKER_DISOWN_TIMEOUT")
+
+	// the malformed value is reported
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	if !assert.NoError(t, err) {
+		return
+	}
+	os.Stderr = w
+
+	args := []string{"--termination-timeout", "1m", "--connection-timeout", "2s"}
+	def = newDefaults(args, "test")
+
+	os.Stderr = stderr
+	w.Close()
+	report, _ := ioutil.ReadAll(r)
+	assert.Contains(t, string(report), "COCAINE_WORKER_DISOWN_TIMEOUT")
+
+	assert.Equal(t, WorkerTimeouts{
+		Heartbeat:      30 * time.Second,
+		Disown:         disownTimeout,
+		CoreConnection: 2 * time.Second,
+		Termination:    time.Minute,
+	}, def.WorkerTimeouts(), "invalid timeouts")
+}
@@ -0,0 +1,4 @@
+package cocaine12
+
+// UnregisterProtocol lets the external tests clean the registry up
+var UnregisterProtocol = unregisterProtocol
@@ -4,19 +4,22 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
 	"syscall"
 )
 
 type request struct {
-	messageTypeDetector
+	MessageTypeDetector
 	fromWorker chan *Message
 	toHandler  chan *Message
 	closed     chan struct{}
+	// closed when the handler is over
+	discarded   chan struct{}
+	discardOnce sync.Once
 }
 
 const (
 	cworkererrorcategory = 42
-	cdefaulterrrorcode   = 100
 )
 
 var (
@@ -29,16 +32,19 @@ var (
 	ErrMalformedErrorMessage = &ErrRequest{
 		Message:  "malformed error message",
 		Category: cworkererrorcategory,
-		Code:     cdefaulterrrorcode,
+		Code:     ErrorMalformedMessage,
 	}
 )
 
-func newRequest(mtd messageTypeDetector) *request {
+// newRequest makes a request which buffers the chunks up to the watermarks.
+// push blocks at the high watermark until the handler reads the chunks.
+func newRequest(mtd MessageTypeDetector, watermarks Watermarks) *request {
 	request := &request{
-		messageTypeDetector: mtd,
+		MessageTypeDetector: mtd,
 		fromWorker:          make(chan *Message),
 		toHandler:           make(chan *Message),
 		closed:              make(chan struct{}),
+		discarded:           make(chan struct{}),
 	}
 
 	go loop(
@@ -48,6 +54,9 @@ func newRequest(mtd messageTypeDetector) *request {
 		request.toHandler,
 		// onclose
 		request.closed,
+		// ondiscard
+		request.discarded,
+		watermarks,
 	)
 
 	return request
@@ -63,39 +72,58 @@ func (request *request) Read(ctx context.Context) ([]byte, error) {
 			return nil, ErrStreamIsClosed
 		}
 
-		if request.isChunk(msg) {
-			if result, isByte := msg.Payload[0].([]byte); isByte {
+		if request.IsChunk(msg) {
+			if result, isByte := msg.payloadBytes(); isByte {
 				return result, nil
 			}
 			return nil, ErrBadPayload
 		}
 
 		// Error message
-		if len(msg.Payload) == 0 {
-			return nil, ErrMalformedErrorMessage
-		}
+		return nil, request.DecodeError(msg)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-		var perr struct {
-			CodeInfo [2]int
-			Message  string
-		}
+// decodeErrorMessage unpacks an error sent by a client
+// as [[category, code], message]
+func decodeErrorMessage(msg *Message) error {
+	if msg.payloadLen() == 0 {
+		return ErrMalformedErrorMessage
+	}
 
-		if err := convertPayload(msg.Payload, &perr); err != nil {
-			return nil, err
-		}
+	var perr struct {
+		CodeInfo [2]int
+		Message  string
+	}
 
-		return nil, &ErrRequest{
-			Message:  perr.Message,
-			Category: perr.CodeInfo[0],
-			Code:     perr.CodeInfo[1],
-		}
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	if err := msg.DecodePayload(&perr); err != nil {
+		return err
+	}
+
+	return &ErrRequest{
+		Message:  perr.Message,
+		Category: perr.CodeInfo[0],
+		Code:     perr.CodeInfo[1],
 	}
 }
 
 func (request *request) push(msg *Message) {
-	request.fromWorker <- msg
+	select {
+	case request.fromWorker <- msg:
+	case <-request.discarded:
+		// nobody reads the request
+	}
+}
+
+func (request *request) queue() (chan<- *Message, <-chan struct{}) {
+	return request.fromWorker, request.discarded
+}
+
+// discard drops the pending and the following chunks
+func (request *request) discard() {
+	request.discardOnce.Do(func() { close(request.discarded) })
 }
 
 func (request *request) Close() {
@@ -103,18 +131,24 @@ func (request *request) Close() {
 }
 
 type response struct {
-	handlerProtocolGenerator
+	HandlerMessageGenerator
+	// bounds the time a chunk waits for the room in the buffer
+	ctx      context.Context
 	session  uint64
 	toWorker asyncSender
 	closed   bool
+	// onClose is called once the response is closed
+	onClose func()
 }
 
-func newResponse(h handlerProtocolGenerator, session uint64, toWorker asyncSender) *response {
+func newResponse(ctx context.Context, h HandlerMessageGenerator, session uint64, toWorker asyncSender, onClose func()) *response {
 	response := &response{
-		handlerProtocolGenerator: h,
-		session:                  session,
-		toWorker:                 toWorker,
-		closed:                   false,
+		HandlerMessageGenerator: h,
+		ctx:                     ctx,
+		session:                 session,
+		toWorker:                toWorker,
+		closed:                  false,
+		onClose:                 onClose,
 	}
 
 	return response
@@ -136,68 +170,127 @@ func (r *response) Write(data []byte) (n int, err error) {
 // ZeroCopyWrite sends data to a client.
 // Response takes the ownership of the buffer, so provided buffer must not be edited.
 func (r *response) ZeroCopyWrite(data []byte) error {
+	return r.ZeroCopyWriteWithHeaders(data)
+}
+
+// ZeroCopyWriteWithHeaders sends data to a client attaching the headers to the chunk.
+// It blocks while the connection is at the high watermark and returns
+// the cause of the context of the handler once it's done.
+func (r *response) ZeroCopyWriteWithHeaders(data []byte, headers ...HeaderField) error {
 	if r.isClosed() {
 		return io.ErrClosedPipe
 	}
 
-	r.toWorker.Send(r.newChunk(r.session, data))
-	return nil
+	msg := r.NewChunk(r.session, data)
+	msg.Headers = newCocaineHeaders(headers)
+	return r.toWorker.SendContext(r.ctx, msg)
 }
 
 // Notify a client about finishing the datastream.
 func (r *response) Close() error {
+	return r.CloseWithHeaders()
+}
+
+// CloseWithHeaders notifies a client about finishing the datastream
+// attaching the headers to the closing frame.
+func (r *response) CloseWithHeaders(headers ...HeaderField) error {
 	if r.isClosed() {
 		// we treat it as a network connection
 		return syscall.EINVAL
 	}
 
 	r.close()
-	r.toWorker.Send(r.newChoke(r.session))
+	r.send(r.NewChoke(r.session), headers)
 	return nil
 }
 
 // Send error to a client. Specify code and message, which describes this error.
 func (r *response) ErrorMsg(code int, message string) error {
+	return r.ErrorMsgWithHeaders(code, message)
+}
+
+// ErrorMsgWithHeaders sends error to a client attaching the headers to it.
+func (r *response) ErrorMsgWithHeaders(code int, message string, headers ...HeaderField) error {
+	return r.sendError(cworkererrorcategory, code, message, headers)
+}
+
+// ErrorWithCategory sends error of the category to a client.
+func (r *response) ErrorWithCategory(category, code int, message string) error {
+	return r.sendError(category, code, message, nil)
+}
+
+func (r *response) sendError(category, code int, message string, headers []HeaderField) error {
 	if r.isClosed() {
 		return io.ErrClosedPipe
 	}
 
 	r.close()
-	r.toWorker.Send(r.newError(
+	r.send(r.NewError(
 		// current session number
 		r.session,
 		// category
-		cworkererrorcategory,
+		category,
 		// error code
 		code,
 		// error message
 		message,
-	))
+	), headers)
 	return nil
 }
 
+// ReplyError sends err to a client. ErrRequest keeps its category and code,
+// an ErrRequest without a category is treated as an application error.
+// Other errors are sent as application errors with ErrorHandlerFailed code.
+func ReplyError(response Response, err error) error {
+	var reqErr *ErrRequest
+	if !errors.As(err, &reqErr) {
+		return response.ErrorWithCategory(ErrorCategoryApplication, ErrorHandlerFailed, err.Error())
+	}
+
+	category := reqErr.Category
+	if category == 0 {
+		category = ErrorCategoryApplication
+	}
+	return response.ErrorWithCategory(category, reqErr.Code, reqErr.Message)
+}
+
+func (r *response) send(msg *Message, headers []HeaderField) {
+	msg.Headers = newCocaineHeaders(headers)
+	r.toWorker.Send(msg)
+}
+
 func (r *response) close() {
 	r.closed = true
+	if r.onClose != nil {
+		r.onClose()
+	}
 }
 
 func (r *response) isClosed() bool {
 	return r.closed
 }
 
-func loop(input <-chan *Message, output chan *Message, onclose <-chan struct{}) {
+func loop(input <-chan *Message, output chan *Message, onclose, ondiscard <-chan struct{}, watermarks Watermarks) {
 	defer close(output)
 
 	var (
 		pending []*Message
 		closed  = onclose
+		level   = bufferLevel{Watermarks: watermarks}
 	)
 
 	for {
 		var (
 			out   chan *Message
 			first *Message
+			// nil at the high watermark to block the sender
+			in <-chan *Message
 		)
 
+		if level.accepting() {
+			in = input
+		}
+
 		if len(pending) > 0 {
 			// if we have data to send,
 			// pick the first element from the queue
@@ -213,8 +306,9 @@ func loop(input <-chan *Message, output chan *Message, onclose <-chan struct{})
 		}
 
 		select {
-		case incoming := <-input:
+		case incoming := <-in:
 			pending = append(pending, incoming)
+			level.push(incoming)
 
 		case out <- first:
 			// help GC a bit
@@ -222,6 +316,10 @@ func loop(input <-chan *Message, output chan *Message, onclose <-chan struct{})
 			// it should be done
 			// without memory copy/allocate
 			pending = pending[1:]
+			level.pop(first)
+
+		case <-ondiscard:
+			return
 
 		case <-closed:
 			// It will be triggered on
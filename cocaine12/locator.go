@@ -2,6 +2,8 @@ package cocaine12
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -9,9 +11,60 @@ import (
 // after last usage
 type Locator interface {
 	Resolve(ctx context.Context, name string) (*ServiceInfo, error)
+	// Cluster returns the endpoints of the cluster nodes by their uuids
+	Cluster(ctx context.Context) (map[string][]EndpointItem, error)
+	// Refresh makes the locator reload the routing groups
+	Refresh(ctx context.Context, groups ...string) error
+	// Watch streams the changes of the services and the routing groups
+	// to a client identified by uuid. See LocatorEvent.
+	Watch(ctx context.Context, uuid string) (<-chan LocatorEvent, error)
 	Close()
 }
 
+// LocatorEventType tells which part of the cluster has changed
+type LocatorEventType int
+
+const (
+	// ServicesChanged means that the services announced
+	// by a node have changed
+	ServicesChanged LocatorEventType = iota
+	// RoutingChanged means that the routing groups have changed
+	RoutingChanged
+)
+
+func (t LocatorEventType) String() string {
+	switch t {
+	case ServicesChanged:
+		return "services"
+	case RoutingChanged:
+		return "routing"
+	default:
+		return fmt.Sprintf("LocatorEventType(%d)", int(t))
+	}
+}
+
+// RingPoint is a point of the consistent hashing ring of a routing group
+type RingPoint struct {
+	Point   uint64
+	Service string
+}
+
+// LocatorEvent is a change delivered by Locator.Watch.
+// The last event of the stream carries Err if the stream
+// is broken by an error other than the cancellation of the context.
+type LocatorEvent struct {
+	Type LocatorEventType
+	// Node is the uuid of the node for ServicesChanged
+	Node string
+	// Services are the services the node announces now.
+	// It is empty if the node has left the cluster.
+	Services map[string]*ServiceInfo
+	// Groups are the rings of the routing groups for RoutingChanged
+	Groups map[string][]RingPoint
+
+	Err error
+}
+
 type locator struct {
 	*Service
 }
@@ -41,11 +94,16 @@ CONN_LOOP:
 		return nil, err
 	}
 
+	options, _ := newServiceOptions(nil)
 	service := Service{
 		ServiceInfo: newLocatorServiceInfo(),
-		socketIO:    sock,
+		conn:        sock,
 		sessions:    newSessions(),
 		stop:        make(chan struct{}),
+		options:     options,
+		state:       newStateTracker(StateConnected, StateClosed),
+		broken:      make(chan struct{}, 1),
+		closed:      make(chan struct{}),
 		args:        endpoints,
 		name:        "locator",
 	}
@@ -75,6 +133,127 @@ func (l *locator) Resolve(ctx context.Context, name string) (*ServiceInfo, error
 	return &serviceInfo, nil
 }
 
+func (l *locator) Cluster(ctx context.Context) (map[string][]EndpointItem, error) {
+	channel, err := l.Service.Call(ctx, "cluster")
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := channel.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes map[string][]EndpointItem
+	if err := answer.ExtractTuple(&nodes); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+func (l *locator) Refresh(ctx context.Context, groups ...string) error {
+	if groups == nil {
+		groups = []string{}
+	}
+
+	channel, err := l.Service.Call(ctx, "refresh", groups)
+	if err != nil {
+		return err
+	}
+
+	answer, err := channel.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	return answer.Err()
+}
+
+func (l *locator) Watch(ctx context.Context, uuid string) (<-chan LocatorEvent, error) {
+	connect, err := l.Service.Call(ctx, "connect", uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	routing, err := l.Service.Call(ctx, "routing", uuid)
+	if err != nil {
+		connect.Cancel()
+		return nil, err
+	}
+
+	var (
+		events = make(chan LocatorEvent)
+		wg     sync.WaitGroup
+	)
+
+	// a broken stream stops the other one
+	ctx, cancel := context.WithCancel(ctx)
+	watch := func(channel Channel, decode func(ServiceResult) (LocatorEvent, error)) {
+		defer wg.Done()
+		defer cancel()
+		defer channel.Cancel()
+
+		for {
+			res, err := channel.Get(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					// avoid blocking on a reader which has gone away
+					select {
+					case events <- LocatorEvent{Err: err}:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+
+			if err = res.Err(); err == nil && channel.Closed() {
+				return
+			}
+
+			event, decodeErr := decode(res)
+			if err == nil {
+				err = decodeErr
+			}
+			if err != nil {
+				event = LocatorEvent{Err: err}
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	wg.Add(2)
+	go watch(connect, decodeServicesEvent)
+	go watch(routing, decodeRoutingEvent)
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return events, nil
+}
+
+func decodeServicesEvent(res ServiceResult) (LocatorEvent, error) {
+	event := LocatorEvent{Type: ServicesChanged}
+	err := res.ExtractTuple(&event.Node, &event.Services)
+	return event, err
+}
+
+func decodeRoutingEvent(res ServiceResult) (LocatorEvent, error) {
+	event := LocatorEvent{Type: RoutingChanged}
+	err := res.ExtractTuple(&event.Groups)
+	return event, err
+}
+
 func (l *locator) Close() {
-	l.socketIO.Close()
+	l.conn.Close()
 }
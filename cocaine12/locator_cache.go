@@ -0,0 +1,261 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLocatorClosed means that Resolve is called after Close
+var ErrLocatorClosed = errors.New("the locator is closed")
+
+// InvalidatingLocator is a Locator which caches resolved services.
+// Service.Reconnect invalidates the entry of the service
+// before resolving it again.
+type InvalidatingLocator interface {
+	Locator
+	Invalidate(name string)
+}
+
+type resolveEntry struct {
+	info    *ServiceInfo
+	expires time.Time
+}
+
+// resolveCall is a resolve in flight shared by concurrent callers.
+// It doesn't depend on the context of any of them and is cancelled
+// once all of them have gone.
+type resolveCall struct {
+	done    chan struct{}
+	info    *ServiceInfo
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// CachingLocator keeps resolved services for the TTL.
+// Concurrent resolves of the same name share a single request
+// to the locator. If the locator fails, the last known info is returned
+// even if it has expired. CachingLocator is safe for concurrent use
+// and is meant to be shared between services.
+type CachingLocator struct {
+	endpoints []string
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	locator Locator
+	entries map[string]*resolveEntry
+	calls   map[string]*resolveCall
+	closed  bool
+}
+
+// NewCachingLocator creates a CachingLocator. It connects
+// to the locator using given endpoints on the first resolve.
+func NewCachingLocator(endpoints []string, ttl time.Duration) *CachingLocator {
+	return &CachingLocator{
+		endpoints: endpoints,
+		ttl:       ttl,
+		entries:   make(map[string]*resolveEntry),
+		calls:     make(map[string]*resolveCall),
+	}
+}
+
+// Resolve returns the cached info of the service or asks the locator
+func (c *CachingLocator) Resolve(ctx context.Context, name string) (*ServiceInfo, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrLocatorClosed
+	}
+
+	if entry, ok := c.entries[name]; ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return entry.info, nil
+	}
+
+	call, inFlight := c.calls[name]
+	if !inFlight {
+		callCtx, cancel := context.WithCancel(context.Background())
+		call = &resolveCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[name] = call
+		go c.resolve(callCtx, name, call)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.info, call.err
+	case <-ctx.Done():
+		c.leave(name, call)
+		return nil, ctx.Err()
+	}
+}
+
+// leave cancels the resolve if nobody waits for it anymore
+func (c *CachingLocator) leave(name string, call *resolveCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		// the next Resolve starts over
+		if c.calls[name] == call {
+			delete(c.calls, name)
+		}
+	}
+}
+
+func (c *CachingLocator) resolve(ctx context.Context, name string, call *resolveCall) {
+	defer call.cancel()
+	info, err := c.resolveRemote(ctx, name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(call.done)
+
+	if c.calls[name] == call {
+		delete(c.calls, name)
+	}
+	if err != nil {
+		// stale data is better than nothing
+		if entry, ok := c.entries[name]; ok {
+			call.info = entry.info
+			return
+		}
+		call.err = err
+		return
+	}
+
+	c.entries[name] = &resolveEntry{
+		info:    info,
+		expires: time.Now().Add(c.ttl),
+	}
+	call.info = info
+}
+
+func (c *CachingLocator) resolveRemote(ctx context.Context, name string) (*ServiceInfo, error) {
+	locator, err := c.getLocator()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := locator.Resolve(ctx, name)
+	if err != nil {
+		c.dropLocator(locator, err)
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *CachingLocator) getLocator() (Locator, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrLocatorClosed
+	}
+
+	if c.locator != nil {
+		return c.locator, nil
+	}
+
+	locator, err := NewLocator(c.endpoints)
+	if err != nil {
+		return nil, err
+	}
+	c.locator = locator
+	return locator, nil
+}
+
+// dropLocator closes the connection if the error means it's broken,
+// so the next call reconnects. The connection is shared by the watchers,
+// so it's kept on a context or application error.
+func (c *CachingLocator) dropLocator(locator Locator, err error) {
+	if !IsRetryable(err) {
+		return
+	}
+
+	c.mu.Lock()
+	if c.locator == locator {
+		c.locator = nil
+	}
+	c.mu.Unlock()
+	locator.Close()
+}
+
+// Cluster asks the locator for the cluster nodes, it is not cached
+func (c *CachingLocator) Cluster(ctx context.Context) (map[string][]EndpointItem, error) {
+	locator, err := c.getLocator()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := locator.Cluster(ctx)
+	if err != nil {
+		c.dropLocator(locator, err)
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// Refresh asks the locator to reload the routing groups
+func (c *CachingLocator) Refresh(ctx context.Context, groups ...string) error {
+	locator, err := c.getLocator()
+	if err != nil {
+		return err
+	}
+
+	if err := locator.Refresh(ctx, groups...); err != nil {
+		c.dropLocator(locator, err)
+		return err
+	}
+	return nil
+}
+
+// Watch streams the changes from the locator. The stream is broken
+// if the connection to the locator is dropped.
+func (c *CachingLocator) Watch(ctx context.Context, uuid string) (<-chan LocatorEvent, error) {
+	locator, err := c.getLocator()
+	if err != nil {
+		return nil, err
+	}
+
+	return locator.Watch(ctx, uuid)
+}
+
+// Invalidate expires the entry of the service, so the next Resolve
+// asks the locator. The entry is still served if the locator fails.
+func (c *CachingLocator) Invalidate(name string) {
+	c.mu.Lock()
+	if entry, ok := c.entries[name]; ok {
+		entry.expires = time.Time{}
+	}
+	c.mu.Unlock()
+}
+
+// InvalidateAll expires all the entries
+func (c *CachingLocator) InvalidateAll() {
+	c.mu.Lock()
+	for _, entry := range c.entries {
+		entry.expires = time.Time{}
+	}
+	c.mu.Unlock()
+}
+
+// Close closes the connection to the locator
+func (c *CachingLocator) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, call := range c.calls {
+		call.cancel()
+	}
+	if c.locator != nil {
+		c.locator.Close()
+		c.locator = nil
+	}
+}
@@ -0,0 +1,150 @@
+package cocaine12_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingLocator(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := cocaine.NewCachingLocator(loc.Endpoints(), 100*time.Millisecond)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, err := cache.Resolve(ctx, "backend")
+			if assert.NoError(t, err) {
+				assert.Equal(t, backends[0].Endpoint(), info.Endpoints[0])
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, loc.Calls(), 1)
+
+	// the services share the cache
+	for i := 0; i < 2; i++ {
+		s, err := cocaine.NewService(ctx, "backend", nil, cocaine.WithLocator(cache))
+		if assert.NoError(t, err) {
+			s.Close()
+		}
+	}
+	assert.Len(t, loc.Calls(), 1)
+
+	// the entry expires
+	time.Sleep(150 * time.Millisecond)
+	_, err = cache.Resolve(ctx, "backend")
+	assert.NoError(t, err)
+	assert.Len(t, loc.Calls(), 2)
+
+	// the stale entry is served if the locator is down
+	loc.Close()
+	cache.Invalidate("backend")
+	info, err := cache.Resolve(ctx, "backend")
+	if assert.NoError(t, err) {
+		assert.Equal(t, backends[0].Endpoint(), info.Endpoints[0])
+	}
+
+	_, err = cache.Resolve(ctx, "missing")
+	assert.Error(t, err)
+
+	cache.Close()
+	_, err = cache.Resolve(ctx, "backend")
+	assert.Equal(t, cocaine.ErrLocatorClosed, err)
+}
+
+func TestCachingLocatorSharedResolve(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cache := cocaine.NewCachingLocator(loc.Endpoints(), time.Millisecond)
+	defer cache.Close()
+
+	events, err := cache.Watch(ctx, "watcher")
+	if !assert.NoError(t, err) {
+		return
+	}
+	routing := func() cocaine.LocatorEvent {
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					t.Fatal("the stream is closed")
+				}
+				if event.Type == cocaine.RoutingChanged || event.Err != nil {
+					return event
+				}
+			case <-ctx.Done():
+				t.Fatal("no event")
+			}
+		}
+	}
+	assert.NoError(t, routing().Err)
+
+	loc.SetResolveDelay(100 * time.Millisecond)
+
+	// the first caller goes away, the second one still gets the info
+	first, leave := context.WithCancel(ctx)
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Resolve(first, "backend")
+		firstErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.Resolve(ctx, "backend")
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	leave()
+	assert.Equal(t, context.Canceled, <-firstErr)
+	assert.NoError(t, <-second)
+
+	// neither a timeout nor an error reply breaks the watchers
+	timeoutCtx, stop := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer stop()
+	_, err = cache.Resolve(timeoutCtx, "missing")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	loc.SetResolveDelay(0)
+	_, err = cache.Resolve(ctx, "missing")
+	assert.Error(t, err)
+
+	ring := []cocaine.RingPoint{{Point: 1, Service: "backend"}}
+	loc.SetRouting(map[string][]cocaine.RingPoint{"backend": ring})
+	event := routing()
+	if assert.NoError(t, event.Err) {
+		assert.Equal(t, map[string][]cocaine.RingPoint{"backend": ring}, event.Groups)
+	}
+}
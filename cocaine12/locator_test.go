@@ -0,0 +1,134 @@
+package cocaine12_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocatorClusterAndRefresh(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	nodes := map[string][]cocaine.EndpointItem{
+		"node-1": {{IP: "10.0.0.1", Port: 10053}},
+		"node-2": {{IP: "10.0.0.2", Port: 10053}, {IP: "::1", Port: 10054}},
+	}
+	loc.SetCluster(nodes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l, err := cocaine.NewLocator(loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to connect to locator: %v", err)
+	}
+	defer l.Close()
+
+	cluster, err := l.Cluster(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, nodes, cluster)
+	}
+
+	assert.NoError(t, l.Refresh(ctx, "storage", "cache"))
+	calls := loc.Calls()
+	if assert.Len(t, calls, 2) {
+		assert.Equal(t, "refresh", calls[1].Method)
+		assert.Len(t, calls[1].Args, 1)
+	}
+}
+
+func TestLocatorWatch(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+	backend.Script(cocainetest.PrimitiveMethod("ping"), cocainetest.Value(0))
+
+	loc.Announce("node-1", map[string]cocainetest.ServiceInfo{"backend": backend.Info()})
+	ring := []cocaine.RingPoint{{Point: 1, Service: "backend-v1"}, {Point: 100, Service: "backend-v2"}}
+	loc.SetRouting(map[string][]cocaine.RingPoint{"backend": ring})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	l, err := cocaine.NewLocator(loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to connect to locator: %v", err)
+	}
+	defer l.Close()
+
+	watchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	events, err := l.Watch(watchCtx, "watcher")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the streams are not ordered with each other
+	var stashed []cocaine.LocatorEvent
+	next := func(typ cocaine.LocatorEventType) cocaine.LocatorEvent {
+		for i, event := range stashed {
+			if event.Type == typ {
+				stashed = append(stashed[:i], stashed[i+1:]...)
+				return event
+			}
+		}
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					t.Fatal("the stream is closed")
+				}
+				assert.NoError(t, event.Err)
+				if event.Type == typ {
+					return event
+				}
+				stashed = append(stashed, event)
+			case <-ctx.Done():
+				t.Fatal("no event")
+			}
+		}
+	}
+
+	// the snapshot comes first
+	event := next(cocaine.ServicesChanged)
+	assert.Equal(t, "node-1", event.Node)
+	if assert.Contains(t, event.Services, "backend") {
+		assert.Equal(t, []cocaine.EndpointItem{backend.Endpoint()}, event.Services["backend"].Endpoints)
+		assert.Contains(t, event.Services["backend"].API.Methods(), "ping")
+	}
+	event = next(cocaine.RoutingChanged)
+	assert.Equal(t, map[string][]cocaine.RingPoint{"backend": ring}, event.Groups)
+
+	// then the changes
+	loc.Announce("node-1", nil)
+	event = next(cocaine.ServicesChanged)
+	assert.Equal(t, "node-1", event.Node)
+	assert.Empty(t, event.Services)
+
+	loc.SetRouting(map[string][]cocaine.RingPoint{})
+	event = next(cocaine.RoutingChanged)
+	assert.Empty(t, event.Groups)
+
+	// the stream ends with the context
+	stop()
+	for event := range events {
+		assert.NoError(t, event.Err)
+	}
+}
@@ -0,0 +1,292 @@
+package cocaine12
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The frames are not decoded as a whole: a frame is split into
+// the raw msgpack of its fields, so the payload can be decoded
+// straight into the target of Extract later.
+
+const maxRawDepth = 64
+
+var errRawTooDeep = errors.New("msgpack object is nested too deep")
+
+// rawHead describes a msgpack object by its first byte
+type rawHead struct {
+	// size of the big-endian length following the first byte
+	lenSize int
+	// the length if it's embedded into the first byte or fixed
+	length int
+	// bytes of the data besides the length, e.g. the type of ext
+	extra int
+	// objects nested per the length: 1 for arrays, 2 for maps
+	nested int
+}
+
+func rawHeadOf(b byte) (rawHead, error) {
+	switch {
+	case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		// fixint, nil, bool
+		return rawHead{}, nil
+	case b <= 0x8f:
+		return rawHead{length: int(b & 0x0f), nested: 2}, nil
+	case b <= 0x9f:
+		return rawHead{length: int(b & 0x0f), nested: 1}, nil
+	case b <= 0xbf:
+		return rawHead{length: int(b & 0x1f)}, nil
+	}
+
+	switch b {
+	case 0xc4, 0xd9:
+		return rawHead{lenSize: 1}, nil
+	case 0xc5, 0xda:
+		return rawHead{lenSize: 2}, nil
+	case 0xc6, 0xdb:
+		return rawHead{lenSize: 4}, nil
+	case 0xc7:
+		return rawHead{lenSize: 1, extra: 1}, nil
+	case 0xc8:
+		return rawHead{lenSize: 2, extra: 1}, nil
+	case 0xc9:
+		return rawHead{lenSize: 4, extra: 1}, nil
+	case 0xcc, 0xd0:
+		return rawHead{length: 1}, nil
+	case 0xcd, 0xd1:
+		return rawHead{length: 2}, nil
+	case 0xca, 0xce, 0xd2:
+		return rawHead{length: 4}, nil
+	case 0xcb, 0xcf, 0xd3:
+		return rawHead{length: 8}, nil
+	case 0xd4:
+		return rawHead{length: 2}, nil
+	case 0xd5:
+		return rawHead{length: 3}, nil
+	case 0xd6:
+		return rawHead{length: 5}, nil
+	case 0xd7:
+		return rawHead{length: 9}, nil
+	case 0xd8:
+		return rawHead{length: 17}, nil
+	case 0xdc:
+		return rawHead{lenSize: 2, nested: 1}, nil
+	case 0xdd:
+		return rawHead{lenSize: 4, nested: 1}, nil
+	case 0xde:
+		return rawHead{lenSize: 2, nested: 2}, nil
+	case 0xdf:
+		return rawHead{lenSize: 4, nested: 2}, nil
+	}
+	return rawHead{}, fmt.Errorf("invalid msgpack type byte 0x%x", b)
+}
+
+type rawReader interface {
+	io.ByteReader
+	// skip passes n bytes
+	skip(n int) error
+}
+
+// readRawHead reads the head of an object and returns it
+// with the length of its data or the number of nested objects
+func readRawHead(r rawReader) (rawHead, int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return rawHead{}, 0, err
+	}
+
+	head, err := rawHeadOf(b)
+	if err != nil {
+		return head, 0, err
+	}
+
+	n := head.length
+	for i := 0; i < head.lenSize; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return head, 0, unexpectedEOF(err)
+		}
+		n = n<<8 | int(b)
+	}
+	return head, n, nil
+}
+
+// skipRaw passes a whole msgpack object
+func skipRaw(r rawReader, depth int) error {
+	if depth > maxRawDepth {
+		return errRawTooDeep
+	}
+
+	head, n, err := readRawHead(r)
+	if err != nil {
+		return err
+	}
+
+	if head.nested == 0 {
+		return unexpectedEOF(r.skip(n + head.extra))
+	}
+
+	for i := 0; i < n*head.nested; i++ {
+		if err := skipRaw(r, depth+1); err != nil {
+			return unexpectedEOF(err)
+		}
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// frameReader reads a msgpack object from the stream keeping its bytes
+type frameReader struct {
+	r   *bufio.Reader
+	buf *bytes.Buffer
+}
+
+func (f *frameReader) ReadByte() (byte, error) {
+	b, err := f.r.ReadByte()
+	if err == nil {
+		f.buf.WriteByte(b)
+	}
+	return b, err
+}
+
+func (f *frameReader) skip(n int) error {
+	_, err := io.CopyN(f.buf, f.r, int64(n))
+	return err
+}
+
+// readRawFrame reads the bytes of the next msgpack object
+func readRawFrame(r *bufio.Reader) ([]byte, error) {
+	f := frameReader{r: r, buf: new(bytes.Buffer)}
+	if err := skipRaw(&f, 0); err != nil {
+		return nil, err
+	}
+	return f.buf.Bytes(), nil
+}
+
+// rawSlice reads the objects of a msgpack buffer without copying
+type rawSlice struct {
+	data []byte
+	pos  int
+}
+
+func (s *rawSlice) ReadByte() (byte, error) {
+	if s.pos >= len(s.data) {
+		return 0, io.EOF
+	}
+	b := s.data[s.pos]
+	s.pos++
+	return b, nil
+}
+
+func (s *rawSlice) skip(n int) error {
+	if n > len(s.data)-s.pos {
+		s.pos = len(s.data)
+		return io.ErrUnexpectedEOF
+	}
+	s.pos += n
+	return nil
+}
+
+// next returns the bytes of the next object
+func (s *rawSlice) next() ([]byte, error) {
+	start := s.pos
+	if err := skipRaw(s, 0); err != nil {
+		return nil, err
+	}
+	return s.data[start:s.pos], nil
+}
+
+// arrayLen reads the head of an array
+func (s *rawSlice) arrayLen() (int, error) {
+	head, n, err := readRawHead(s)
+	if err != nil {
+		return 0, err
+	}
+	if head.nested != 1 {
+		return 0, errors.New("msgpack object is not an array")
+	}
+	return n, nil
+}
+
+// uint reads an unsigned integer
+func (s *rawSlice) uint() (uint64, error) {
+	b, err := s.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int
+	switch {
+	case b <= 0x7f:
+		return uint64(b), nil
+	case b == 0xcc, b == 0xd0:
+		size = 1
+	case b == 0xcd, b == 0xd1:
+		size = 2
+	case b == 0xce, b == 0xd2:
+		size = 4
+	case b == 0xcf, b == 0xd3:
+		size = 8
+	default:
+		return 0, fmt.Errorf("msgpack object 0x%x is not an unsigned integer", b)
+	}
+
+	if len(s.data)-s.pos < size {
+		return 0, io.ErrUnexpectedEOF
+	}
+	var buf [8]byte
+	copy(buf[8-size:], s.data[s.pos:s.pos+size])
+	s.pos += size
+
+	// the signed types are accepted for the non-negative values only
+	if b >= 0xd0 && buf[8-size]&0x80 != 0 {
+		return 0, fmt.Errorf("negative value where an unsigned integer is expected")
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// rawArrayLen returns the number of items of the raw array
+func rawArrayLen(data []byte) int {
+	s := rawSlice{data: data}
+	n, err := s.arrayLen()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// rawFirstBytes returns the data of the first item of the raw array
+// if the item is a string or binary
+func rawFirstBytes(data []byte) ([]byte, bool) {
+	s := rawSlice{data: data}
+	if n, err := s.arrayLen(); err != nil || n == 0 {
+		return nil, false
+	}
+
+	b, err := s.ReadByte()
+	if err != nil {
+		return nil, false
+	}
+	switch {
+	case b >= 0xa0 && b <= 0xbf, b >= 0xc4 && b <= 0xc6, b >= 0xd9 && b <= 0xdb:
+	default:
+		return nil, false
+	}
+	s.pos--
+
+	_, n, err := readRawHead(&s)
+	if err != nil || n > len(s.data)-s.pos {
+		return nil, false
+	}
+	return s.data[s.pos : s.pos+n], true
+}
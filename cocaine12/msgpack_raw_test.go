@@ -0,0 +1,69 @@
+package cocaine12
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+)
+
+func TestWireFormatRawDecode(t *testing.T) {
+	messages := []*Message{
+		{
+			CommonMessageInfo: CommonMessageInfo{Session: 1, MsgType: 0},
+			Payload:           []interface{}{[]byte("chunk")},
+			Headers:           newCocaineHeaders([]HeaderField{{"X-Request-Id", []byte("abc")}}),
+		},
+		{
+			CommonMessageInfo: CommonMessageInfo{Session: 1 << 40, MsgType: 300},
+			Payload: []interface{}{
+				-1, 1.5, true, nil, strings.Repeat("s", 70000),
+				map[string]interface{}{"nested": []interface{}{1, []int{2, 3}}},
+			},
+		},
+		{
+			CommonMessageInfo: CommonMessageInfo{Session: 2, MsgType: 1},
+			Payload:           []interface{}{},
+		},
+	}
+
+	var buf bytes.Buffer
+	encoder := codec.NewEncoder(&buf, hAsocket)
+	for _, msg := range messages {
+		assert.NoError(t, encoder.Encode(msg))
+	}
+
+	reader := bufio.NewReader(&buf)
+	for _, msg := range messages {
+		decoded, err := defaultWireFormat{}.decode(reader)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		var expected []interface{}
+		assert.NoError(t, convertPayload(msg.Payload, &expected))
+		assert.Equal(t, msg.CommonMessageInfo, decoded.CommonMessageInfo)
+		assert.Equal(t, len(msg.Payload), decoded.payloadLen())
+		assert.Equal(t, expected, decoded.payload())
+		assert.Equal(t, msg.Headers.Fields(), decoded.HeaderFields())
+	}
+
+	_, err := defaultWireFormat{}.decode(reader)
+	assert.Equal(t, io.EOF, err)
+
+	// a string item is sliced out of the frame
+	var frame []byte
+	assert.NoError(t, codec.NewEncoderBytes(&frame, hAsocket).Encode(messages[0]))
+	decoded, err := defaultWireFormat{}.decode(bufio.NewReader(bytes.NewReader(frame)))
+	assert.NoError(t, err)
+	chunk, ok := decoded.payloadBytes()
+	assert.True(t, ok)
+	assert.Equal(t, []byte("chunk"), chunk)
+
+	_, err = defaultWireFormat{}.decode(bufio.NewReader(bytes.NewReader(frame[:len(frame)-1])))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
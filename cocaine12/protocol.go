@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+
+	"github.com/ugorji/go/codec"
 )
 
 const (
@@ -125,8 +127,80 @@ func decodeRawTraceName(name []byte) (uint64, error) {
 	return 0, ErrInvalidTraceType
 }
 
+// HeaderField is a decoded header of a message
+type HeaderField struct {
+	Name  string
+	Value []byte
+}
+
 type CocaineHeaders []interface{}
 
+// Fields decodes the headers into name-value pairs.
+// Headers which names are indexes of unknown table entries
+// or which values are not binary are skipped.
+func (h CocaineHeaders) Fields() []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+	for _, header := range h {
+		if field, ok := decodeHeaderField(header); ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func newCocaineHeaders(fields []HeaderField) CocaineHeaders {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	headers := make(CocaineHeaders, 0, len(fields))
+	for _, field := range fields {
+		headers = append(headers, []interface{}{false, field.Name, field.Value})
+	}
+	return headers
+}
+
+func decodeHeaderField(header interface{}) (field HeaderField, ok bool) {
+	t, ok := header.([]interface{})
+	if !ok || len(t) != 3 {
+		return field, false
+	}
+
+	switch name := t[1].(type) {
+	case []byte:
+		field.Name = string(name)
+	case string:
+		field.Name = name
+	default:
+		number, _, err := getTrace(header)
+		if err != nil {
+			return field, false
+		}
+		switch number {
+		case traceId:
+			field.Name = string(traceIdName)
+		case spanId:
+			field.Name = string(spanIdName)
+		case parentId:
+			field.Name = string(parentIdName)
+		default:
+			return field, false
+		}
+	}
+
+	switch val := t[2].(type) {
+	case []byte:
+		field.Value = val
+	case string:
+		field.Value = []byte(val)
+	case nil:
+	default:
+		return field, false
+	}
+
+	return field, true
+}
+
 func (h CocaineHeaders) getTraceData() (traceInfo TraceInfo, err error) {
 	var i = 0
 	for _, header := range h {
@@ -206,8 +280,94 @@ type Message struct {
 	CommonMessageInfo
 	Payload []interface{}
 	Headers CocaineHeaders
+
+	// A message read from the wire keeps the raw msgpack
+	// of Payload and Headers, which are decoded on demand
+	rawPayload []byte
+	rawHeaders []byte
 }
 
 func (m *Message) String() string {
-	return fmt.Sprintf("message %v %v payload %v", m.MsgType, m.Session, m.Payload)
+	return fmt.Sprintf("message %v %v payload %v", m.MsgType, m.Session, m.payload())
+}
+
+// payload returns the decoded Payload.
+// It's nil if the raw payload is malformed.
+func (m *Message) payload() []interface{} {
+	if m.Payload == nil && m.rawPayload != nil {
+		codec.NewDecoderBytes(m.rawPayload, hAsocket).Decode(&m.Payload)
+	}
+	return m.Payload
+}
+
+// headers returns the decoded Headers
+func (m *Message) headers() CocaineHeaders {
+	if m.Headers == nil && m.rawHeaders != nil {
+		codec.NewDecoderBytes(m.rawHeaders, hAsocket).Decode(&m.Headers)
+	}
+	return m.Headers
+}
+
+// payloadLen returns the number of the payload items
+func (m *Message) payloadLen() int {
+	if m.rawPayload != nil {
+		return rawArrayLen(m.rawPayload)
+	}
+	return len(m.Payload)
+}
+
+// DecodePayload unpacks the payload into out, which is a pointer
+// to a slice or a struct. A message read from the wire keeps its payload
+// encoded and leaves Payload nil, so a ProtocolDispatcher uses DecodePayload
+// to read it.
+func (m *Message) DecodePayload(out interface{}) error {
+	if m.rawPayload != nil {
+		return codec.NewDecoderBytes(m.rawPayload, payloadHandler).Decode(out)
+	}
+	return convertPayload(m.Payload, out)
+}
+
+// HeaderFields decodes the headers into name-value pairs.
+// It's the way to read the headers of a message read from the wire,
+// which leaves Headers nil.
+func (m *Message) HeaderFields() []HeaderField {
+	return m.headers().Fields()
+}
+
+// size estimates the memory held by the message:
+// the raw frame or the binary and string payload items
+func (m *Message) size() int {
+	if m.rawPayload != nil {
+		return len(m.rawPayload) + len(m.rawHeaders)
+	}
+
+	n := 0
+	for _, item := range m.Payload {
+		switch item := item.(type) {
+		case []byte:
+			n += len(item)
+		case string:
+			n += len(item)
+		}
+	}
+	return n
+}
+
+// payloadBytes returns the first payload item if it's a string or binary.
+// A raw item isn't copied.
+func (m *Message) payloadBytes() ([]byte, bool) {
+	if m.rawPayload != nil {
+		return rawFirstBytes(m.rawPayload)
+	}
+
+	if len(m.Payload) == 0 {
+		return nil, false
+	}
+	switch item := m.Payload[0].(type) {
+	case []byte:
+		return item, true
+	case string:
+		return []byte(item), true
+	}
+	return nil, false
 }
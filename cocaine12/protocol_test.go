@@ -134,6 +134,16 @@ func TestMessageUnpackTraceAsString(t *testing.T) {
 	assert.Equal(t, uint64(124), traceInfo.Parent)
 }
 
+func TestLocatorDispatch(t *testing.T) {
+	// the ids of the locator methods in cocaine-core v12
+	api := newLocatorServiceInfo().API
+	for id, name := range []string{"resolve", "connect", "refresh", "cluster", "publish", "routing"} {
+		methodID, err := api.MethodByName(name)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(id), methodID, name)
+	}
+}
+
 func TestHeaders(t *testing.T) {
 	var (
 		//trace.pack_trace(trace.Trace(traceid=9000, spanid=11000, parentid=8000))
@@ -181,6 +191,21 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, uint64(8000), traceInfo.Parent)
 }
 
+func TestHeaderFieldsSkipUnknownIndexes(t *testing.T) {
+	headers := CocaineHeaders{
+		[]interface{}{false, uint64(traceId), []byte("trace")},
+		// indexes of the table entries other than tracing ones
+		[]interface{}{false, uint64(1), []byte("unknown")},
+		[]interface{}{false, int64(90), []byte("unknown")},
+		[]interface{}{false, []byte("X-Request-Id"), []byte("abc")},
+	}
+
+	assert.Equal(t, []HeaderField{
+		{string(traceIdName), []byte("trace")},
+		{"X-Request-Id", []byte("abc")},
+	}, headers.Fields())
+}
+
 func TestHeadersPackUnpack(t *testing.T) {
 	trace := TraceInfo{
 		Trace:  uint64(100),
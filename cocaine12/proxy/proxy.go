@@ -50,7 +50,14 @@ func packRequest(req *http.Request) ([]byte, error) {
 	return task, nil
 }
 
-func process(w http.ResponseWriter, r *http.Request) {
+// resolveTTL is how long the proxy trusts resolved endpoints
+const resolveTTL = time.Minute
+
+type server struct {
+	locator *cocaine.CachingLocator
+}
+
+func (s *server) process(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("X-Powered-By", "Cocaine")
 	defer r.Body.Close()
 	var (
@@ -92,7 +99,7 @@ func process(w http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
-	app, err := cocaine.NewService(ctx, service, nil)
+	app, err := cocaine.NewService(ctx, service, nil, cocaine.WithLocator(s.locator))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -149,26 +156,20 @@ func process(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(startLine.Code)
 
-BODY:
-	for {
-		res, err := channel.Get(ctx)
-		switch {
-		case err != nil:
-			break BODY
-		case res.Err() != nil:
-			break BODY
-		case channel.Closed():
-			break BODY
-		default:
-			res.ExtractTuple(&body)
-			w.Write(body)
-			body = body[:]
-		}
+	stream := cocaine.NewStream(channel)
+	for stream.Next(ctx) {
+		stream.Value().ExtractTuple(&body)
+		w.Write(body)
+		body = body[:]
 	}
 }
 
 func NewServer() http.Handler {
+	s := &server{
+		locator: cocaine.NewCachingLocator(nil, resolveTTL),
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", process)
+	mux.HandleFunc("/", s.process)
 	return mux
 }
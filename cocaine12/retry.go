@@ -0,0 +1,206 @@
+package cocaine12
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// RetryPolicy makes Service.Call repeat a failed call of a one-shot
+// method, which has no downstream and replies with a single value or error.
+// Calls of other methods are never retried. The attempts are limited
+// by the deadline of the context of the call as well.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, 1 disables retries
+	MaxAttempts int
+	// Backoff is the delay between the attempts, DefaultBackoff if zero
+	Backoff Backoff
+	// Retryable tells if the call may be repeated after the error,
+	// IsRetryable if nil
+	Retryable func(error) bool
+}
+
+func (p *RetryPolicy) withDefaults() *RetryPolicy {
+	policy := *p
+	if policy.Backoff == (Backoff{}) {
+		policy.Backoff = DefaultBackoff()
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsRetryable
+	}
+	return &policy
+}
+
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be positive, got %d", p.MaxAttempts)
+	}
+	return p.Backoff.validate()
+}
+
+// IsRetryable reports if the error means that the call has not reached
+// the service: the connection is lost or can not be established
+func IsRetryable(err error) bool {
+	// context.DeadlineExceeded implements net.Error
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return false
+	}
+
+	switch err := err.(type) {
+	case *ServiceError:
+		return err.Code == ErrDisconnected
+	case MultiConnectionError:
+		return true
+	case net.Error:
+		return true
+	}
+
+	switch err {
+	case ErrReconnecting, ErrNoHealthyConnections:
+		return true
+	}
+	return false
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy overrides the retry policy of the service for the calls
+// made with the returned context. Pass MaxAttempts 1 to disable retries.
+// An invalid policy fails the calls.
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy.withDefaults())
+}
+
+func retryPolicyFromContext(ctx context.Context) *RetryPolicy {
+	policy, _ := ctx.Value(retryPolicyKey{}).(*RetryPolicy)
+	return policy
+}
+
+func isOneShot(item dispatchItem) bool {
+	if item.Downstream.Type() != emptyDispatch || item.Upstream.Type() != otherDispatch {
+		return false
+	}
+
+	for _, next := range *item.Upstream {
+		if next.Description.Type() != emptyDispatch {
+			return false
+		}
+	}
+	return true
+}
+
+// retryPolicy returns the policy of the call or nil if it's not retried.
+// The policy of the service is validated by NewService,
+// the one of the context is validated here.
+func (service *Service) retryPolicy(ctx context.Context, name string) (*RetryPolicy, error) {
+	policy := retryPolicyFromContext(ctx)
+	if policy != nil {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("invalid retry policy of the context: %v", err)
+		}
+	} else {
+		policy = service.options.retry
+	}
+
+	if policy == nil || policy.MaxAttempts < 2 || !service.isOneShot(name) {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// isOneShot reports if the method has no downstream
+// and replies with a single value or error
+func (service *Service) isOneShot(name string) bool {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	methodNum, err := service.API.MethodByName(name)
+	return err == nil && isOneShot(service.API[methodNum])
+}
+
+// callWithRetry waits for the reply and repeats the call while it fails
+// with a retryable error. The reply is returned in a finished Channel.
+func (service *Service) callWithRetry(ctx context.Context, policy *RetryPolicy, name string, args ...interface{}) (Channel, error) {
+	var (
+		lastRes ServiceResult
+		lastErr error
+	)
+
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := policy.Backoff.Delay(attempt - 1)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				break
+			}
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		ch, err := service.connectAndCall(ctx, name, args...)
+		if err != nil {
+			if !policy.Retryable(err) {
+				return nil, err
+			}
+			lastRes, lastErr = nil, err
+			continue
+		}
+
+		res, err := ch.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := res.Err(); err == nil || !policy.Retryable(err) {
+			return &finishedChannel{res: res}, nil
+		}
+		lastRes, lastErr = res, nil
+	}
+
+	if lastRes != nil {
+		return &finishedChannel{res: lastRes}, nil
+	}
+	return nil, lastErr
+}
+
+// finishedChannel is a Channel of a one-shot call
+// which reply has already been received
+type finishedChannel struct {
+	mu    sync.Mutex
+	res   ServiceResult
+	taken bool
+}
+
+func (ch *finishedChannel) Get(ctx context.Context) (ServiceResult, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.taken {
+		return nil, ErrStreamIsClosed
+	}
+	ch.taken = true
+	return ch.res, nil
+}
+
+func (ch *finishedChannel) Closed() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.taken
+}
+
+func (ch *finishedChannel) Cancel() {
+	ch.mu.Lock()
+	ch.taken = true
+	ch.mu.Unlock()
+}
+
+func (ch *finishedChannel) push(ServiceResult) {}
+
+func (ch *finishedChannel) Call(ctx context.Context, name string, args ...interface{}) error {
+	return fmt.Errorf("tx is done")
+}
@@ -0,0 +1,128 @@
+package cocaine12_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+const errorBusy = 42
+
+func isBusy(err error) bool {
+	e, ok := err.(*cocaine.ErrRequest)
+	return ok && e.Code == errorBusy
+}
+
+func TestServiceRetry(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	var (
+		mu       sync.Mutex
+		calls    int
+		failures int
+	)
+	// the calls fail while there are failures left
+	fail := func(n int) {
+		mu.Lock()
+		failures = n
+		mu.Unlock()
+	}
+	flaky := func(cocainetest.Call) []cocainetest.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if failures > 0 {
+			failures--
+			return []cocainetest.Reply{cocainetest.Error(1, errorBusy, "busy")}
+		}
+		return []cocainetest.Reply{cocainetest.Value(calls)}
+	}
+	backend.Handle(cocainetest.PrimitiveMethod("ping"), flaky)
+	backend.Handle(cocainetest.StreamingMethod("watch"), func(call cocainetest.Call) []cocainetest.Reply {
+		return append(flaky(call), cocainetest.Close())
+	})
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithRetry(cocaine.RetryPolicy{
+			MaxAttempts: 3,
+			Backoff:     testReconnectBackoff,
+			Retryable:   isBusy,
+		}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	fail(2)
+	n, err := ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// the streaming method is not retried
+	fail(1)
+	ch, err := s.Call(ctx, "watch")
+	if assert.NoError(t, err) {
+		res, err := ch.Get(ctx)
+		assert.NoError(t, err)
+		assert.True(t, isBusy(res.Err()))
+	}
+
+	// the attempts are exhausted
+	fail(2)
+	ctx2 := cocaine.WithRetryPolicy(ctx, cocaine.RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     testReconnectBackoff,
+		Retryable:   isBusy,
+	})
+	_, err = ping(ctx2, s)
+	assert.True(t, isBusy(err))
+
+	// the call is not repeated if the deadline comes earlier
+	fail(1)
+	ctx3, cancel3 := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel3()
+	_, err = ping(ctx3, s)
+	assert.True(t, isBusy(err))
+
+	// an invalid policy of the context fails the call before it's sent
+	for _, policy := range []cocaine.RetryPolicy{
+		{},
+		{MaxAttempts: 2, Backoff: cocaine.Backoff{Initial: -time.Millisecond}},
+	} {
+		_, err = s.Call(cocaine.WithRetryPolicy(ctx, policy), "ping")
+		if assert.Error(t, err, "%+v", policy) {
+			assert.Contains(t, err.Error(), "retry policy")
+		}
+	}
+
+	mu.Lock()
+	assert.Equal(t, 7, calls)
+	mu.Unlock()
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, cocaine.IsRetryable(&cocaine.ServiceError{Code: cocaine.ErrDisconnected}))
+	assert.True(t, cocaine.IsRetryable(cocaine.ErrNoHealthyConnections))
+	assert.True(t, cocaine.IsRetryable(cocaine.ErrReconnecting))
+	assert.False(t, cocaine.IsRetryable(cocaine.NewApplicationError(1, "failed")))
+	assert.False(t, cocaine.IsRetryable(context.DeadlineExceeded))
+}
@@ -0,0 +1,51 @@
+package cocaine12_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Get sets the error to an error reply, so the reply
+// must not be touched by push once it's queued. Run with -race.
+func TestRxPushErrorReply(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	backend.Script(cocainetest.PrimitiveMethod("fail"), cocainetest.Error(1, 2, "failed"))
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		ch, err := s.Call(ctx, "fail")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		res, err := ch.Get(ctx)
+		if assert.NoError(t, err) {
+			assert.Equal(t, &cocaine.ErrRequest{Message: "failed", Category: 1, Code: 2}, res.Err())
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 const (
@@ -52,14 +54,35 @@ type ServiceResult interface {
 	ExtractTuple(...interface{}) error
 	Result() (uint64, []interface{}, error)
 	Err() error
+	// Headers returns the decoded headers of the received frame
+	Headers() []HeaderField
 
 	setError(error)
+	// methodID returns the message type without decoding the payload
+	methodID() uint64
+	// payloadLen returns the number of the payload items
+	payloadLen() int
 }
 
 type serviceRes struct {
 	payload []interface{}
 	method  uint64
 	err     error
+	headers CocaineHeaders
+
+	// the raw msgpack of a received frame
+	rawPayload []byte
+	rawHeaders []byte
+}
+
+func newServiceRes(msg *Message) *serviceRes {
+	return &serviceRes{
+		payload:    msg.Payload,
+		method:     msg.MsgType,
+		headers:    msg.Headers,
+		rawPayload: msg.rawPayload,
+		rawHeaders: msg.rawHeaders,
+	}
 }
 
 //Unpacks the result of the called method in the passed structure.
@@ -68,6 +91,9 @@ func (s *serviceRes) Extract(target interface{}) (err error) {
 	if s.err != nil {
 		return s.err
 	}
+	if s.rawPayload != nil {
+		return codec.NewDecoderBytes(s.rawPayload, payloadHandler).Decode(target)
+	}
 	return convertPayload(s.payload, target)
 }
 
@@ -79,7 +105,15 @@ func (s *serviceRes) ExtractTuple(args ...interface{}) error {
 // Extract(target ...interface{})
 
 func (s *serviceRes) Result() (uint64, []interface{}, error) {
-	return s.method, s.payload, s.err
+	if s.rawPayload == nil || s.err != nil {
+		return s.method, s.payload, s.err
+	}
+
+	var payload []interface{}
+	if err := codec.NewDecoderBytes(s.rawPayload, hAsocket).Decode(&payload); err != nil {
+		return s.method, nil, err
+	}
+	return s.method, payload, nil
 }
 
 //Error status
@@ -94,6 +128,27 @@ func (s *serviceRes) Error() string {
 	return s.err.Error()
 }
 
+// Headers returns the decoded headers of the received frame
+func (s *serviceRes) Headers() []HeaderField {
+	if s.rawHeaders != nil {
+		var headers CocaineHeaders
+		codec.NewDecoderBytes(s.rawHeaders, hAsocket).Decode(&headers)
+		return headers.Fields()
+	}
+	return s.headers.Fields()
+}
+
+func (s *serviceRes) methodID() uint64 {
+	return s.method
+}
+
+func (s *serviceRes) payloadLen() int {
+	if s.rawPayload != nil {
+		return rawArrayLen(s.rawPayload)
+	}
+	return len(s.payload)
+}
+
 func (s *serviceRes) setError(err error) {
 	s.err = err
 }
@@ -109,6 +164,10 @@ func (err *ServiceError) Error() string {
 }
 
 // Allows you to invoke methods of services and send events to other cloud applications.
+//
+// Service doesn't expose the Read, Write and Send methods of its connection
+// anymore, as there is no single connection in the pooled mode.
+// Use Call to talk to the service and IsClosed to check its connection.
 type Service struct {
 	// Tracking a connection state
 	mutex sync.RWMutex
@@ -117,12 +176,26 @@ type Service struct {
 	// To keep ordering of opening new sessions
 	muKeepSessionOrder sync.Mutex
 
-	socketIO
 	*ServiceInfo
 
+	// the connection of the single mode, nil in the pooled mode
+	conn socketIO
+
 	sessions *sessions
 	stop     chan struct{}
 
+	// connections of the pooled mode, nil otherwise
+	pool    *connPool
+	options *serviceOptions
+
+	state *stateTracker[ServiceState]
+	// nil without WithCircuitBreaker
+	breaker *circuitBreaker
+	// wakes up the supervisor
+	broken    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
 	args []string
 	name string
 
@@ -142,14 +215,14 @@ func serviceResolve(ctx context.Context, name string, endpoints []string) (*Serv
 	return l.Resolve(ctx, name)
 }
 
-func serviceCreateIO(endpoints []EndpointItem) (socketIO, error) {
+func serviceCreateIO(endpoints []EndpointItem, timeout time.Duration) (socketIO, error) {
 	if len(endpoints) == 0 {
 		return nil, ErrZeroEndpoints
 	}
 
 	var mErr = make(MultiConnectionError, 0)
 	for _, endpoint := range endpoints {
-		sock, err := newAsyncConnection("tcp", endpoint.String(), time.Second*1)
+		sock, err := newAsyncConnection("tcp", endpoint.String(), timeout)
 		if err != nil {
 			mErr = append(mErr, ConnectionError{endpoint, err})
 			continue
@@ -161,47 +234,76 @@ func serviceCreateIO(endpoints []EndpointItem) (socketIO, error) {
 	return nil, mErr
 }
 
-func NewService(ctx context.Context, name string, endpoints []string) (s *Service, err error) {
-	info, err := serviceResolve(ctx, name, endpoints)
+// NewService resolves the service and connects to it.
+// By default it keeps a single connection to the first reachable endpoint,
+// WithPoolSize enables the pooled mode.
+func NewService(ctx context.Context, name string, endpoints []string, opts ...ServiceOption) (s *Service, err error) {
+	options, err := newServiceOptions(opts)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to resolve service %s: %v", name, err)
+		return nil, err
 	}
 
-	sock, err := serviceCreateIO(info.Endpoints)
+	info, err := options.resolve(ctx, name, endpoints)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to connect to service %s: %s", name, err)
+		return nil, fmt.Errorf("Unable to resolve service %s: %v", name, err)
 	}
 
 	s = &Service{
-		socketIO:    sock,
 		ServiceInfo: info,
 		sessions:    newSessions(),
 		stop:        make(chan struct{}),
+		options:     options,
+		state:       newStateTracker(StateConnected, StateClosed),
+		broken:      make(chan struct{}, 1),
+		closed:      make(chan struct{}),
 		args:        endpoints,
 		name:        name,
 		epoch:       0,
 		id:          fmt.Sprintf("%x", rand.Int63()),
 	}
-	go s.loop()
+
+	if options.breaker != nil {
+		s.breaker = newCircuitBreaker(*options.breaker)
+	}
+
+	if options.poolSize > 0 {
+		if s.pool, err = newConnPool(info.Endpoints, options, s.notifyDisconnected); err != nil {
+			return nil, fmt.Errorf("Unable to connect to service %s: %s", name, err)
+		}
+	} else {
+		sock, err := serviceCreateIO(info.Endpoints, options.connectionTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to service %s: %s", name, err)
+		}
+
+		s.conn = sock
+		go s.loop()
+	}
+
+	if options.reconnect != nil {
+		go s.supervise()
+	}
 	return s, nil
 }
 
 func (service *Service) loop() {
 	epoch := service.epoch
 
-	for data := range service.socketIO.Read() {
-		if ch, ok := service.sessions.Get(data.Session); ok {
-			ch.push(&serviceRes{
-				payload: data.Payload,
-				method:  data.MsgType,
-			})
-		}
+	for data := range service.conn.Read() {
+		dispatchServiceResult(service.sessions, data)
 	}
 
 	service.mutex.Lock()
 	defer service.mutex.Unlock()
 	if epoch == service.epoch {
 		service.pushDisconnectedError()
+		service.notifyDisconnected()
+	}
+}
+
+func dispatchServiceResult(sessions *sessions, data *Message) {
+	if ch, ok := sessions.Get(data.Session); ok {
+		ch.push(newServiceRes(data))
 	}
 }
 
@@ -212,18 +314,40 @@ func (service *Service) Reconnect(ctx context.Context, force bool) error {
 	ctx, closeReconnectionSpan := NewSpan(ctx, "%s %s reconnection", service.name, service.id)
 	defer closeReconnectionSpan()
 
+	if service.isClosed() {
+		return ErrServiceClosed
+	}
+
 	if !force && !service.disconnected() {
 		return nil
 	}
 
 	service.pushDisconnectedError()
 
+	// The endpoints may have been changed
+	if locator, ok := service.options.locator.(InvalidatingLocator); ok {
+		locator.Invalidate(service.name)
+	}
+
 	// Create new socket
-	info, err := serviceResolve(ctx, service.name, service.args)
+	info, err := service.options.resolve(ctx, service.name, service.args)
 	if err != nil {
 		return err
 	}
-	sock, err := serviceCreateIO(info.Endpoints)
+
+	if service.pool != nil {
+		pool, err := newConnPool(info.Endpoints, service.options, service.notifyDisconnected)
+		if err != nil {
+			return err
+		}
+
+		service.pool.close()
+		service.pool = pool
+		service.ServiceInfo = info
+		return nil
+	}
+
+	sock, err := serviceCreateIO(info.Endpoints, service.options.connectionTimeout)
 	if err != nil {
 		return err
 	}
@@ -234,15 +358,23 @@ func (service *Service) Reconnect(ctx context.Context, force bool) error {
 	// Reattach channels and network IO
 	service.stop = make(chan struct{})
 	service.epoch++
-	service.socketIO = sock
+	service.conn = sock
 	// Start service loop
 	go service.loop()
 	return nil
 }
 
 func (service *Service) pushDisconnectedError() {
-	for _, key := range service.sessions.Keys() {
-		if ch, ok := service.sessions.Get(key); ok {
+	if service.pool != nil {
+		// the pool notifies the sessions of its connections
+		return
+	}
+	pushDisconnectedError(service.sessions)
+}
+
+func pushDisconnectedError(sessions *sessions) {
+	for _, key := range sessions.Keys() {
+		if ch, ok := sessions.Get(key); ok {
 			ch.push(&serviceRes{
 				payload: nil,
 				method:  1,
@@ -251,7 +383,7 @@ func (service *Service) pushDisconnectedError() {
 	}
 }
 
-func (service *Service) call(ctx context.Context, name string, args ...interface{}) (Channel, error) {
+func (service *Service) call(ctx context.Context, guard *breakerCall, name string, args ...interface{}) (*channel, error) {
 	service.mutex.RLock()
 	defer service.mutex.RUnlock()
 
@@ -304,18 +436,35 @@ func (service *Service) call(ctx context.Context, name string, args ...interface
 		}
 	}
 
+	var (
+		sessions           = service.sessions
+		send               = service.sendMsg
+		muKeepSessionOrder = &service.muKeepSessionOrder
+	)
+
+	if service.pool != nil {
+		conn, err := service.pool.pick()
+		if err != nil {
+			traceCall()
+			return nil, err
+		}
+		sessions, send, muKeepSessionOrder = conn.sessions, conn.Send, &conn.muKeepSessionOrder
+	}
+
 	ch := channel{
 		traceReceived: traceReceivedCall,
 		traceSent:     traceSentCall,
+		guard:         guard,
 		rx: rx{
-			service:    service,
+			sessions:   sessions,
 			pushBuffer: make(chan ServiceResult, 1),
+			cancelled:  make(chan struct{}),
 			rxTree:     service.ServiceInfo.API[methodNum].Upstream,
 			id:         0,
 			done:       false,
 		},
 		tx: tx{
-			service: service,
+			send:    send,
 			txTree:  service.ServiceInfo.API[methodNum].Downstream,
 			id:      0,
 			done:    false,
@@ -325,10 +474,10 @@ func (service *Service) call(ctx context.Context, name string, args ...interface
 
 	// We must create new sessions in the monotonic order
 	// Protect sending messages, which open new sessions.
-	service.muKeepSessionOrder.Lock()
-	defer service.muKeepSessionOrder.Unlock()
+	muKeepSessionOrder.Lock()
+	defer muKeepSessionOrder.Unlock()
 
-	ch.tx.id = service.sessions.Attach(&ch)
+	ch.tx.id = sessions.Attach(&ch)
 	ch.rx.id = ch.tx.id
 
 	msg := &Message{
@@ -337,50 +486,143 @@ func (service *Service) call(ctx context.Context, name string, args ...interface
 		Headers:           headers,
 	}
 
-	service.sendMsg(msg)
+	send(msg)
 	return &ch, nil
 }
 
 func (service *Service) disconnected() bool {
+	if service.pool != nil {
+		return service.pool.healthy() == 0
+	}
+
 	select {
-	case <-service.IsClosed():
+	case <-service.conn.IsClosed():
 		return true
 	default:
 		return false
 	}
 }
 
+// IsClosed reports whether the service is closed or has lost the connection.
+// In the pooled mode the connection is lost once no endpoint is healthy.
+func (service *Service) IsClosed() bool {
+	if service.isClosed() {
+		return true
+	}
+
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+	return service.disconnected()
+}
+
 func (service *Service) sendMsg(msg *Message) {
 	service.mutex.RLock()
-	service.socketIO.Send(msg)
+	service.conn.Send(msg)
 	service.mutex.RUnlock()
 }
 
 //Calls a remote method by name and pass args
+//A call of a one-shot method is retried according to the retry policy,
+//see WithRetry.
 func (service *Service) Call(ctx context.Context, name string, args ...interface{}) (Channel, error) {
+	policy, err := service.retryPolicy(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		return service.callWithRetry(ctx, policy, name, args...)
+	}
+	return service.connectAndCall(ctx, name, args...)
+}
+
+func (service *Service) connectAndCall(ctx context.Context, name string, args ...interface{}) (Channel, error) {
+	var guard *breakerCall
+	if service.breaker != nil {
+		var err error
+		if guard, err = service.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := service.connect(ctx); err != nil {
+		guard.done(err)
+		return nil, err
+	}
+
+	ch, err := service.call(ctx, guard, name, args...)
+	if err != nil {
+		if IsRetryable(err) {
+			guard.done(err)
+		} else {
+			// an unknown method tells nothing about the service
+			guard.cancel()
+		}
+		return nil, err
+	}
+
+	ch.cancelOnDone(ctx)
+	return ch, nil
+}
+
+// connect waits for the background reconnection
+// or reconnects the service itself
+func (service *Service) connect(ctx context.Context) error {
+	if service.options.reconnect != nil {
+		return service.waitConnected(ctx)
+	}
+
 	service.mutex.RLock()
 	disconnected := service.disconnected()
 	service.mutex.RUnlock()
 
 	if disconnected {
-		if err := service.Reconnect(ctx, false); err != nil {
-			return nil, err
-		}
+		return service.Reconnect(ctx, false)
 	}
+	return nil
+}
+
+// OpenSessions returns the number of the sessions waiting for replies
+func (service *Service) OpenSessions() int {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
 
-	return service.call(ctx, name, args...)
+	if service.pool != nil {
+		return service.pool.openSessions()
+	}
+	return service.sessions.Len()
 }
 
 // Disposes resources of a service. You must call this method if the service isn't used anymore.
+// It's safe to call it more than once.
 func (service *Service) Close() {
-	service.mutex.RLock()
-	// Broadcast all related
-	// goroutines about disposing
-	service.close()
-	service.mutex.RUnlock()
+	service.closeOnce.Do(func() {
+		// stop the supervisor before the connection is closed
+		close(service.closed)
+
+		service.mutex.RLock()
+		// Broadcast all related
+		// goroutines about disposing
+		service.close()
+		service.mutex.RUnlock()
+
+		service.state.set(StateClosed)
+	})
+}
+
+func (service *Service) isClosed() bool {
+	select {
+	case <-service.closed:
+		return true
+	default:
+		return false
+	}
 }
 
 func (service *Service) close() {
 	close(service.stop)
-	service.socketIO.Close()
+	if service.pool != nil {
+		service.pool.close()
+		return
+	}
+	service.conn.Close()
 }
@@ -0,0 +1,193 @@
+package cocaine12
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BalancePolicy chooses a connection of a pooled Service for a new call
+type BalancePolicy int
+
+const (
+	// RoundRobin iterates over the healthy connections
+	RoundRobin BalancePolicy = iota
+	// LeastInFlight picks the connection with the fewest open sessions
+	LeastInFlight
+)
+
+func (p BalancePolicy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastInFlight:
+		return "least-in-flight"
+	default:
+		return fmt.Sprintf("BalancePolicy(%d)", int(p))
+	}
+}
+
+const (
+	defaultEjectionTimeout   = time.Second * 5
+	defaultConnectionTimeout = time.Second * 1
+	defaultAttemptTimeout    = time.Second * 5
+)
+
+// ReconnectPolicy configures the background reconnection of a Service.
+// The zero fields get the defaults.
+type ReconnectPolicy struct {
+	// Backoff is the delay between the failed attempts,
+	// DefaultBackoff by default
+	Backoff Backoff
+	// AttemptTimeout limits an attempt to resolve the service and connect
+	AttemptTimeout time.Duration
+	// FailFast makes Call return ErrReconnecting while the service
+	// is reconnecting. By default Call waits for the connection
+	// until its context is done.
+	FailFast bool
+}
+
+type serviceOptions struct {
+	poolSize          int
+	balancer          BalancePolicy
+	ejectionTimeout   time.Duration
+	connectionTimeout time.Duration
+	locator           Locator
+	reconnect         *ReconnectPolicy
+	retry             *RetryPolicy
+	breaker           *BreakerPolicy
+}
+
+func (o *serviceOptions) resolve(ctx context.Context, name string, endpoints []string) (*ServiceInfo, error) {
+	if o.locator != nil {
+		return o.locator.Resolve(ctx, name)
+	}
+	return serviceResolve(ctx, name, endpoints)
+}
+
+// ServiceOption configures Service
+type ServiceOption func(*serviceOptions)
+
+func newServiceOptions(opts []ServiceOption) (*serviceOptions, error) {
+	options := &serviceOptions{
+		balancer:          RoundRobin,
+		ejectionTimeout:   defaultEjectionTimeout,
+		connectionTimeout: defaultConnectionTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	switch {
+	case options.poolSize < 0:
+		return nil, fmt.Errorf("invalid service options: pool size must not be negative, got %d",
+			options.poolSize)
+	case options.balancer != RoundRobin && options.balancer != LeastInFlight:
+		return nil, fmt.Errorf("invalid service options: unknown balancer %v", options.balancer)
+	case options.ejectionTimeout <= 0:
+		return nil, fmt.Errorf("invalid service options: ejection timeout must be positive, got %v",
+			options.ejectionTimeout)
+	case options.connectionTimeout <= 0:
+		return nil, fmt.Errorf("invalid service options: connection timeout must be positive, got %v",
+			options.connectionTimeout)
+	}
+
+	if policy := options.reconnect; policy != nil {
+		if policy.Backoff == (Backoff{}) {
+			policy.Backoff = DefaultBackoff()
+		}
+		if policy.AttemptTimeout == 0 {
+			policy.AttemptTimeout = defaultAttemptTimeout
+		}
+
+		if err := policy.Backoff.validate(); err != nil {
+			return nil, fmt.Errorf("invalid service options: reconnect backoff: %v", err)
+		}
+		if policy.AttemptTimeout < 0 {
+			return nil, fmt.Errorf("invalid service options: attempt timeout must be positive, got %v",
+				policy.AttemptTimeout)
+		}
+	}
+
+	if options.breaker != nil {
+		if err := options.breaker.validate(); err != nil {
+			return nil, fmt.Errorf("invalid service options: circuit breaker: %v", err)
+		}
+	}
+
+	if options.retry != nil {
+		if err := options.retry.validate(); err != nil {
+			return nil, fmt.Errorf("invalid service options: retry policy: %v", err)
+		}
+	}
+
+	return options, nil
+}
+
+// WithPoolSize enables the pooled mode: the service keeps n connections
+// spread over the resolved endpoints. Zero keeps a single connection
+// to the first reachable endpoint.
+func WithPoolSize(n int) ServiceOption {
+	return func(o *serviceOptions) {
+		o.poolSize = n
+	}
+}
+
+// WithBalancer sets how a pooled service picks a connection for a call
+func WithBalancer(policy BalancePolicy) ServiceOption {
+	return func(o *serviceOptions) {
+		o.balancer = policy
+	}
+}
+
+// WithEjectionTimeout sets how long a pooled service avoids an endpoint
+// after its connection has failed
+func WithEjectionTimeout(d time.Duration) ServiceOption {
+	return func(o *serviceOptions) {
+		o.ejectionTimeout = d
+	}
+}
+
+// WithLocator makes the service resolve itself via the locator
+// instead of connecting to the locator every time.
+// The locator is not closed by the service.
+func WithLocator(locator Locator) ServiceOption {
+	return func(o *serviceOptions) {
+		o.locator = locator
+	}
+}
+
+// WithReconnect makes the service reconnect in the background
+// as soon as the connection is lost instead of doing it in Call.
+// See Service.State and Service.OnStateChange.
+func WithReconnect(policy ReconnectPolicy) ServiceOption {
+	return func(o *serviceOptions) {
+		o.reconnect = &policy
+	}
+}
+
+// WithRetry sets the retry policy of the calls of one-shot methods.
+// It can be overridden per call by WithRetryPolicy.
+func WithRetry(policy RetryPolicy) ServiceOption {
+	return func(o *serviceOptions) {
+		o.retry = policy.withDefaults()
+	}
+}
+
+// WithCircuitBreaker makes Call fail fast with ErrCircuitOpen
+// while the service is failing. See Service.BreakerState
+// and Service.OnBreakerStateChange.
+func WithCircuitBreaker(policy BreakerPolicy) ServiceOption {
+	return func(o *serviceOptions) {
+		policy.withDefaults()
+		o.breaker = &policy
+	}
+}
+
+// WithServiceConnectionTimeout limits the time to connect to an endpoint
+func WithServiceConnectionTimeout(d time.Duration) ServiceOption {
+	return func(o *serviceOptions) {
+		o.connectionTimeout = d
+	}
+}
@@ -0,0 +1,213 @@
+package cocaine12
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyConnections means that all the connections of a pooled
+// Service are broken and their endpoints are ejected
+var ErrNoHealthyConnections = errors.New("no healthy connections in the pool")
+
+// serviceConn is a connection of a pooled Service.
+// Each connection has its own space of session ids.
+type serviceConn struct {
+	socketIO
+	sessions *sessions
+	// To keep ordering of opening new sessions
+	muKeepSessionOrder sync.Mutex
+}
+
+func (c *serviceConn) closed() bool {
+	select {
+	case <-c.IsClosed():
+		return true
+	default:
+		return false
+	}
+}
+
+// poolSlot keeps a connection to the endpoint
+type poolSlot struct {
+	endpoint EndpointItem
+	conn     *serviceConn
+	// the endpoint is not used until then
+	ejectedUntil time.Time
+	dialing      bool
+}
+
+func (s *poolSlot) healthy() bool {
+	return s.conn != nil && !s.conn.closed()
+}
+
+type connPool struct {
+	options *serviceOptions
+	// called when the last healthy connection is lost
+	onBroken func()
+
+	mu      sync.Mutex
+	slots   []*poolSlot
+	next    int
+	stopped bool
+}
+
+// newConnPool spreads size connections over the endpoints.
+// It fails only if no endpoint is reachable.
+func newConnPool(endpoints []EndpointItem, options *serviceOptions, onBroken func()) (*connPool, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrZeroEndpoints
+	}
+
+	p := &connPool{
+		options:  options,
+		onBroken: onBroken,
+		slots:    make([]*poolSlot, 0, options.poolSize),
+	}
+
+	var mErr = make(MultiConnectionError, 0)
+	for i := 0; i < options.poolSize; i++ {
+		slot := &poolSlot{endpoint: endpoints[i%len(endpoints)]}
+		p.slots = append(p.slots, slot)
+
+		if err := p.dial(slot); err != nil {
+			mErr = append(mErr, ConnectionError{slot.endpoint, err})
+		}
+	}
+
+	if len(mErr) == len(p.slots) {
+		return nil, mErr
+	}
+	return p, nil
+}
+
+func (p *connPool) dial(slot *poolSlot) error {
+	sock, err := newAsyncConnection("tcp", slot.endpoint.String(), p.options.connectionTimeout)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slot.dialing = false
+	if err != nil {
+		slot.ejectedUntil = time.Now().Add(p.options.ejectionTimeout)
+		return err
+	}
+
+	if p.stopped {
+		sock.Close()
+		return nil
+	}
+
+	conn := &serviceConn{
+		socketIO: sock,
+		sessions: newSessions(),
+	}
+	slot.conn = conn
+	go p.loop(slot, conn)
+	return nil
+}
+
+func (p *connPool) loop(slot *poolSlot, conn *serviceConn) {
+	for data := range conn.Read() {
+		dispatchServiceResult(conn.sessions, data)
+	}
+
+	pushDisconnectedError(conn.sessions)
+
+	p.mu.Lock()
+	if slot.conn == conn {
+		slot.conn = nil
+		slot.ejectedUntil = time.Now().Add(p.options.ejectionTimeout)
+	}
+	broken := !p.stopped && p.healthyLocked() == 0
+	p.mu.Unlock()
+
+	if broken {
+		p.onBroken()
+	}
+}
+
+// pick chooses a healthy connection according to the balancer
+// and starts reconnecting the slots which ejection has expired
+func (p *connPool) pick() (*serviceConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return nil, ErrNoHealthyConnections
+	}
+
+	var (
+		now     = time.Now()
+		healthy = make([]*serviceConn, 0, len(p.slots))
+	)
+	for _, slot := range p.slots {
+		switch {
+		case slot.healthy():
+			healthy = append(healthy, slot.conn)
+		case !slot.dialing && !now.Before(slot.ejectedUntil):
+			slot.dialing = true
+			go p.dial(slot)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnections
+	}
+
+	switch p.options.balancer {
+	case LeastInFlight:
+		best := healthy[0]
+		for _, conn := range healthy[1:] {
+			if conn.sessions.Len() < best.sessions.Len() {
+				best = conn
+			}
+		}
+		return best, nil
+	default:
+		p.next++
+		return healthy[p.next%len(healthy)], nil
+	}
+}
+
+// healthy returns the number of healthy connections
+func (p *connPool) healthy() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthyLocked()
+}
+
+func (p *connPool) healthyLocked() int {
+	var n int
+	for _, slot := range p.slots {
+		if slot.healthy() {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *connPool) openSessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	for _, slot := range p.slots {
+		if slot.conn != nil {
+			n += slot.conn.sessions.Len()
+		}
+	}
+	return n
+}
+
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	for _, slot := range p.slots {
+		if slot.conn != nil {
+			slot.conn.Close()
+		}
+	}
+}
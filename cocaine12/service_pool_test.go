@@ -0,0 +1,205 @@
+package cocaine12_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func startBackends(t *testing.T, loc *cocainetest.Locator, n int) []*cocainetest.Service {
+	var (
+		backends []*cocainetest.Service
+		info     cocainetest.ServiceInfo
+	)
+
+	for i := 0; i < n; i++ {
+		backend, err := cocainetest.NewService()
+		if err != nil {
+			t.Fatalf("unable to start service: %v", err)
+		}
+		backend.Script(cocainetest.PrimitiveMethod("ping"), cocainetest.Value(i))
+		backend.Script(cocainetest.StreamingMethod("watch"), cocainetest.Chunk(i))
+
+		info = backend.Info()
+		backends = append(backends, backend)
+	}
+
+	info.Endpoints = nil
+	for _, backend := range backends {
+		info.Endpoints = append(info.Endpoints, backend.Endpoint())
+	}
+	loc.Register("backend", info)
+	return backends
+}
+
+func ping(ctx context.Context, s *cocaine.Service) (int, error) {
+	ch, err := s.Call(ctx, "ping")
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := ch.Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var i int
+	err = res.ExtractTuple(&i)
+	return i, err
+}
+
+func TestServicePoolRoundRobin(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 2)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithPoolSize(4),
+		cocaine.WithEjectionTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	hits := make(map[int]int)
+	for i := 0; i < 8; i++ {
+		backend, err := ping(ctx, s)
+		assert.NoError(t, err)
+		hits[backend]++
+	}
+	assert.Equal(t, map[int]int{0: 4, 1: 4}, hits)
+
+	// the second backend goes away and its endpoint is ejected
+	endpoint := backends[1].Endpoint()
+	backends[1].Close()
+
+	assert.Eventually(t, func() bool {
+		hits = make(map[int]int)
+		for i := 0; i < 4; i++ {
+			backend, err := ping(ctx, s)
+			if err != nil {
+				return false
+			}
+			hits[backend]++
+		}
+		return hits[0] == 4
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// it comes back after the ejection timeout
+	revived, err := cocainetest.NewServiceAt(fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port))
+	if err != nil {
+		t.Fatalf("unable to restart service: %v", err)
+	}
+	defer revived.Close()
+	revived.Script(cocainetest.PrimitiveMethod("ping"), cocainetest.Value(1))
+
+	assert.Eventually(t, func() bool {
+		backend, err := ping(ctx, s)
+		return err == nil && backend == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServicePoolLeastInFlight(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 2)
+	for _, backend := range backends {
+		defer backend.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithPoolSize(2),
+		cocaine.WithBalancer(cocaine.LeastInFlight))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	// the stream is never closed, so its session stays in flight
+	watch, err := s.Call(ctx, "watch")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := watch.Get(ctx)
+	assert.NoError(t, err)
+	var busy int
+	assert.NoError(t, res.ExtractTuple(&busy))
+
+	for i := 0; i < 3; i++ {
+		backend, err := ping(ctx, s)
+		assert.NoError(t, err)
+		assert.Equal(t, 1-busy, backend)
+	}
+}
+
+func TestServiceHidesConnection(t *testing.T) {
+	// the connection is nil in the pooled mode,
+	// so its methods must not be reachable through the service
+	service := reflect.TypeOf(&cocaine.Service{})
+	for _, name := range []string{"Read", "Write", "Send", "FlushStats"} {
+		_, ok := service.MethodByName(name)
+		assert.False(t, ok, "Service has the %s method of the connection", name)
+	}
+}
+
+func TestServiceIsClosed(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, opts := range [][]cocaine.ServiceOption{nil, {cocaine.WithPoolSize(2)}} {
+		s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(), opts...)
+		if err != nil {
+			t.Fatalf("unable to create service: %v", err)
+		}
+		assert.False(t, s.IsClosed())
+
+		s.Close()
+		assert.True(t, s.IsClosed())
+	}
+}
+
+func TestServiceOptionsValidation(t *testing.T) {
+	_, err := cocaine.NewService(context.Background(), "backend", nil, cocaine.WithPoolSize(-1))
+	assert.Error(t, err)
+
+	for _, policy := range []cocaine.BreakerPolicy{
+		{Window: 5 * time.Millisecond},
+		{ErrorRate: -0.5},
+		{SlowCallRate: 1.5},
+	} {
+		_, err = cocaine.NewService(context.Background(), "backend", nil, cocaine.WithCircuitBreaker(policy))
+		if assert.Error(t, err, "%+v", policy) {
+			assert.Contains(t, err.Error(), "circuit breaker")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrReconnecting is returned by Call of a service which reconnects
+	// in the background with FailFast policy
+	ErrReconnecting = errors.New("the service is reconnecting")
+	// ErrServiceClosed is returned by Call after Close
+	ErrServiceClosed = errors.New("the service is closed")
+)
+
+// ServiceState is the state of the connection of a Service
+type ServiceState int
+
+const (
+	// StateConnected means that the service is ready for calls
+	StateConnected ServiceState = iota
+	// StateReconnecting means that the connection is lost and
+	// the background reconnection is in progress
+	StateReconnecting
+	// StateClosed means that the service is closed
+	StateClosed
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ServiceState(%d)", int(s))
+	}
+}
+
+type stateChange[S comparable] struct {
+	from, to S
+}
+
+// stateTracker keeps the state and delivers its changes to the handlers
+// in order. A handler may change the state or add handlers itself.
+// The state is not changed anymore once it becomes final.
+type stateTracker[S comparable] struct {
+	final []S
+
+	mu         sync.Mutex
+	state      S
+	changed    chan struct{}
+	handlers   []func(from, to S)
+	pending    []stateChange[S]
+	delivering bool
+}
+
+func newStateTracker[S comparable](initial S, final ...S) *stateTracker[S] {
+	return &stateTracker[S]{
+		final:   final,
+		state:   initial,
+		changed: make(chan struct{}),
+	}
+}
+
+// get returns the state and the channel closed on its change
+func (t *stateTracker[S]) get() (S, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state, t.changed
+}
+
+func (t *stateTracker[S]) set(state S) {
+	t.update(state)
+	t.deliver()
+}
+
+// update changes the state, deliver passes the change to the handlers.
+// It allows to change the state under a lock and call the handlers
+// after it is released.
+func (t *stateTracker[S]) update(state S) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == state || slices.Contains(t.final, t.state) {
+		return
+	}
+
+	t.pending = append(t.pending, stateChange[S]{t.state, state})
+	t.state = state
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *stateTracker[S]) deliver() {
+	t.mu.Lock()
+	if t.delivering {
+		// the running delivery picks the changes up
+		t.mu.Unlock()
+		return
+	}
+	t.delivering = true
+
+	for len(t.pending) > 0 {
+		change := t.pending[0]
+		t.pending = t.pending[1:]
+		handlers := t.handlers
+		t.mu.Unlock()
+
+		for _, handler := range handlers {
+			handler(change.from, change.to)
+		}
+
+		t.mu.Lock()
+	}
+	t.delivering = false
+	t.mu.Unlock()
+}
+
+func (t *stateTracker[S]) onChange(handler func(from, to S)) {
+	t.mu.Lock()
+	// copy on write as the handlers may be being called
+	t.handlers = append(t.handlers[:len(t.handlers):len(t.handlers)], handler)
+	t.mu.Unlock()
+}
+
+// State returns the state of the connection. Without WithReconnect
+// the service is reconnected lazily by Call, so it stays
+// StateConnected until it is closed.
+func (service *Service) State() ServiceState {
+	state, _ := service.state.get()
+	return state
+}
+
+// OnStateChange adds the handler of the state changes.
+// The handlers are called one change at a time in order.
+func (service *Service) OnStateChange(handler func(from, to ServiceState)) {
+	service.state.onChange(handler)
+}
+
+// notifyDisconnected wakes up the supervisor
+func (service *Service) notifyDisconnected() {
+	select {
+	case service.broken <- struct{}{}:
+	default:
+	}
+}
+
+// supervise reconnects the service in the background
+// each time the connection is lost until the service is closed
+func (service *Service) supervise() {
+	policy := service.options.reconnect
+	for {
+		select {
+		case <-service.broken:
+		case <-service.closed:
+			return
+		}
+
+		service.mutex.RLock()
+		disconnected := service.disconnected()
+		service.mutex.RUnlock()
+		if !disconnected {
+			continue
+		}
+
+		service.state.set(StateReconnecting)
+		for attempt := 0; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), policy.AttemptTimeout)
+			err := service.Reconnect(ctx, false)
+			cancel()
+			if err == nil {
+				break
+			}
+
+			if err == ErrServiceClosed {
+				return
+			}
+
+			select {
+			case <-service.closed:
+				return
+			case <-time.After(policy.Backoff.Delay(attempt)):
+			}
+		}
+		service.state.set(StateConnected)
+	}
+}
+
+// waitConnected blocks a call while the service is reconnecting
+func (service *Service) waitConnected(ctx context.Context) error {
+	for {
+		service.mutex.RLock()
+		disconnected := service.disconnected()
+		service.mutex.RUnlock()
+
+		state, changed := service.state.get()
+		switch {
+		case state == StateClosed:
+			return ErrServiceClosed
+		case state == StateConnected && !disconnected:
+			return nil
+		case service.options.reconnect.FailFast:
+			return ErrReconnecting
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package cocaine12_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+var testReconnectBackoff = cocaine.Backoff{
+	Initial:    10 * time.Millisecond,
+	Max:        50 * time.Millisecond,
+	Multiplier: 2,
+}
+
+func TestServiceReconnect(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	endpoint := backends[0].Endpoint()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithReconnect(cocaine.ReconnectPolicy{Backoff: testReconnectBackoff}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+
+	var (
+		mu     sync.Mutex
+		states []cocaine.ServiceState
+	)
+	s.OnStateChange(func(from, to cocaine.ServiceState) {
+		mu.Lock()
+		states = append(states, to)
+		mu.Unlock()
+	})
+
+	_, err = ping(ctx, s)
+	assert.NoError(t, err)
+	assert.Equal(t, cocaine.StateConnected, s.State())
+
+	backends[0].Close()
+	assert.Eventually(t, func() bool {
+		return s.State() == cocaine.StateReconnecting
+	}, 2*time.Second, time.Millisecond)
+
+	// the call waits for the connection
+	result := make(chan error, 1)
+	go func() {
+		_, err := ping(ctx, s)
+		result <- err
+	}()
+
+	// let the supervisor fail a few times
+	time.Sleep(50 * time.Millisecond)
+	revived, err := cocainetest.NewServiceAt(fmt.Sprintf("%s:%d", endpoint.IP, endpoint.Port))
+	if err != nil {
+		t.Fatalf("unable to restart service: %v", err)
+	}
+	defer revived.Close()
+	revived.Script(cocainetest.PrimitiveMethod("ping"), cocainetest.Value(0))
+
+	assert.NoError(t, <-result)
+	assert.Equal(t, cocaine.StateConnected, s.State())
+
+	s.Close()
+	_, err = s.Call(ctx, "ping")
+	assert.Equal(t, cocaine.ErrServiceClosed, err)
+
+	// a repeated Close is a no-op
+	assert.NotPanics(t, s.Close)
+
+	mu.Lock()
+	assert.Equal(t, []cocaine.ServiceState{
+		cocaine.StateReconnecting,
+		cocaine.StateConnected,
+		cocaine.StateClosed,
+	}, states)
+	mu.Unlock()
+}
+
+func TestServiceReconnectFailFast(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(),
+		cocaine.WithReconnect(cocaine.ReconnectPolicy{
+			Backoff:  testReconnectBackoff,
+			FailFast: true,
+		}))
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	backends[0].Close()
+	assert.Eventually(t, func() bool {
+		_, err := s.Call(ctx, "ping")
+		return err == cocaine.ErrReconnecting
+	}, 2*time.Second, time.Millisecond)
+}
+
+func TestServiceCloseTwice(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backends := startBackends(t, loc, 1)
+	defer backends[0].Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, opts := range [][]cocaine.ServiceOption{nil, {cocaine.WithPoolSize(2)}} {
+		s, err := cocaine.NewService(ctx, "backend", loc.Endpoints(), opts...)
+		if err != nil {
+			t.Fatalf("unable to create service: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NotPanics(t, s.Close)
+			}()
+		}
+		wg.Wait()
+
+		assert.NotPanics(t, s.Close)
+		assert.Equal(t, cocaine.StateClosed, s.State())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := cocaine.Backoff{
+		Initial:    100 * time.Millisecond,
+		Max:        time.Second,
+		Multiplier: 3,
+	}
+	assert.Equal(t, 100*time.Millisecond, b.Delay(0))
+	assert.Equal(t, 300*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 900*time.Millisecond, b.Delay(2))
+	assert.Equal(t, time.Second, b.Delay(3))
+	assert.Equal(t, time.Second, b.Delay(1000))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.Delay(0)
+		assert.True(t, delay >= 50*time.Millisecond && delay <= 150*time.Millisecond, delay)
+	}
+
+	_, err := cocaine.NewService(context.Background(), "backend", nil,
+		cocaine.WithReconnect(cocaine.ReconnectPolicy{Backoff: cocaine.Backoff{Initial: time.Second}}))
+	assert.Error(t, err)
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func TestCreateIO(t *testing.T) {
-	if _, err := serviceCreateIO(nil); err != ErrZeroEndpoints {
+	if _, err := serviceCreateIO(nil, defaultConnectionTimeout); err != ErrZeroEndpoints {
 		t.Fatalf("%v is expected, but %v has been returned", ErrZeroEndpoints, err)
 	}
 
@@ -20,7 +20,7 @@ func TestCreateIO(t *testing.T) {
 		EndpointItem{"129.0.0.1", 10000},
 		EndpointItem{"128.0.0.1", 10000},
 	}
-	_, err := serviceCreateIO(endpoints)
+	_, err := serviceCreateIO(endpoints, defaultConnectionTimeout)
 	merr, ok := err.(MultiConnectionError)
 	if !ok {
 		t.Fatal(err)
@@ -0,0 +1,45 @@
+package cocaine12
+
+import (
+	"context"
+	"time"
+)
+
+type sessionInfoKey struct{}
+
+// SessionInfo describes the session a handler is serving
+type SessionInfo struct {
+	// ID is the session id assigned by cocaine-runtime
+	ID uint64
+	// Event is the name of the invoked event
+	Event string
+	// Arrived is the time the invoke message was received
+	Arrived time.Time
+	// Headers are the decoded headers of the invoke message
+	Headers []HeaderField
+	// WorkerID is the UUID of the worker
+	WorkerID string
+	// AppName is the name of the application
+	AppName string
+}
+
+// Header returns the value of the first header with the name
+func (s *SessionInfo) Header(name string) ([]byte, bool) {
+	for _, header := range s.Headers {
+		if header.Name == name {
+			return header.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SessionFromContext returns the info of the session attached
+// to the context of a handler
+func SessionFromContext(ctx context.Context) (*SessionInfo, bool) {
+	info, ok := ctx.Value(sessionInfoKey{}).(*SessionInfo)
+	return info, ok
+}
+
+func withSessionInfo(ctx context.Context, info *SessionInfo) context.Context {
+	return context.WithValue(ctx, sessionInfoKey{}, info)
+}
@@ -54,6 +54,12 @@ func (s *sessions) Get(id uint64) (Channel, bool) {
 	return session, ok
 }
 
+func (s *sessions) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.links)
+}
+
 func (s *sessions) Keys() []uint64 {
 	s.RLock()
 
@@ -0,0 +1,96 @@
+package cocaine12
+
+import (
+	"context"
+)
+
+// Stream iterates over the replies of a channel:
+//
+//	stream := NewStream(ch)
+//	for stream.Next(ctx) {
+//		stream.Value().Extract(&chunk)
+//	}
+//	if err := stream.Err(); err != nil {
+//		...
+//	}
+//
+// The protocol transitions are followed by Get, so the stream
+// ends on the terminal frame of the protocol. The terminal frame is
+// yielded if it carries a payload, like the value of a one-shot method,
+// and is skipped otherwise, like close of a streaming method.
+type Stream struct {
+	ch    Channel
+	value ServiceResult
+	err   error
+	done  bool
+}
+
+// NewStream returns a Stream reading the channel
+func NewStream(ch Channel) *Stream {
+	return &Stream{ch: ch}
+}
+
+// Next waits for the next reply. It returns false when the stream
+// is over, either by the terminal frame or by an error.
+func (s *Stream) Next(ctx context.Context) bool {
+	if s.done {
+		return false
+	}
+
+	res, err := s.ch.Get(ctx)
+	if err == nil {
+		err = res.Err()
+	}
+	if err != nil {
+		s.value, s.err, s.done = nil, err, true
+		return false
+	}
+
+	if s.ch.Closed() {
+		s.done = true
+		if res.payloadLen() == 0 {
+			s.value = nil
+			return false
+		}
+	}
+
+	s.value = res
+	return true
+}
+
+// Value returns the reply received by Next
+func (s *Stream) Value() ServiceResult {
+	return s.value
+}
+
+// Err returns the error which has ended the stream: an error frame
+// as *ErrRequest, a disconnection or the context error.
+// It is nil if the stream is not over or is closed by the service.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close cancels the channel, see Channel.Cancel
+func (s *Stream) Close() {
+	s.done = true
+	s.ch.Cancel()
+}
+
+// Chan returns a channel of the replies which is closed at the end
+// of the stream, Err tells why it is over afterwards. The stream
+// must not be used otherwise until the returned channel is closed.
+func (s *Stream) Chan(ctx context.Context) <-chan ServiceResult {
+	values := make(chan ServiceResult)
+	go func() {
+		defer close(values)
+		for s.Next(ctx) {
+			select {
+			case values <- s.Value():
+			case <-ctx.Done():
+				s.err, s.done = ctx.Err(), true
+				return
+			}
+		}
+	}()
+	return values
+}
@@ -0,0 +1,86 @@
+package cocaine12_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	backend.Script(cocainetest.StreamingMethod("numbers"),
+		cocainetest.Chunk(1), cocainetest.Chunk(2), cocainetest.Chunk(3), cocainetest.Close())
+	backend.Script(cocainetest.StreamingMethod("broken"),
+		cocainetest.Chunk(1), cocainetest.Error(1, 7, "broken"))
+	backend.Script(cocainetest.PrimitiveMethod("ping"), cocainetest.Value(5))
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	collect := func(method string) ([]int, error) {
+		ch, err := s.Call(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+
+		var values []int
+		stream := cocaine.NewStream(ch)
+		for stream.Next(ctx) {
+			var n int
+			assert.NoError(t, stream.Value().ExtractTuple(&n))
+			values = append(values, n)
+		}
+		assert.False(t, stream.Next(ctx))
+		return values, stream.Err()
+	}
+
+	values, err := collect("numbers")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, values)
+
+	values, err = collect("broken")
+	assert.Equal(t, []int{1}, values)
+	if assert.IsType(t, &cocaine.ErrRequest{}, err) {
+		assert.Equal(t, 7, err.(*cocaine.ErrRequest).Code)
+	}
+
+	// the value of a one-shot method is yielded
+	values, err = collect("ping")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5}, values)
+
+	ch, err := s.Call(ctx, "numbers")
+	if !assert.NoError(t, err) {
+		return
+	}
+	stream := cocaine.NewStream(ch)
+	var n int
+	for res := range stream.Chan(ctx) {
+		assert.NoError(t, res.ExtractTuple(&n))
+	}
+	assert.NoError(t, stream.Err())
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, s.OpenSessions())
+}
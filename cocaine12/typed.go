@@ -0,0 +1,200 @@
+package cocaine12
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec packs and unpacks the chunks of typed handlers
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// MsgpackCodec is the default codec of typed handlers
+	MsgpackCodec Codec = msgpackCodec{}
+	// JSONCodec packs chunks as JSON documents
+	JSONCodec Codec = jsonCodec{}
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf []byte
+	err := codec.NewEncoderBytes(&buf, payloadHandler).Encode(v)
+	return buf, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, payloadHandler).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type typedOptions struct {
+	codec Codec
+}
+
+// TypedOption configures a typed handler
+type TypedOption func(*typedOptions)
+
+// WithCodec sets the codec of a typed handler
+func WithCodec(c Codec) TypedOption {
+	return func(o *typedOptions) {
+		o.codec = c
+	}
+}
+
+func newTypedOptions(opts []TypedOption) *typedOptions {
+	options := &typedOptions{
+		codec: MsgpackCodec,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// TypedRequest reads and decodes chunks sent by a client
+type TypedRequest[In any] struct {
+	req   Request
+	codec Codec
+}
+
+// Recv reads the next chunk. It returns ErrStreamIsClosed
+// once the client has closed the stream.
+func (r *TypedRequest[In]) Recv(ctx context.Context) (In, error) {
+	var in In
+	data, err := r.req.Read(ctx)
+	if err != nil {
+		return in, err
+	}
+
+	if err := r.codec.Unmarshal(data, &in); err != nil {
+		return in, &typedDecodeError{err}
+	}
+	return in, nil
+}
+
+// TypedResponse encodes and sends chunks to a client
+type TypedResponse[Out any] struct {
+	res   Response
+	codec Codec
+}
+
+// Send encodes the value and sends it as a chunk
+func (r *TypedResponse[Out]) Send(out Out) error {
+	data, err := r.codec.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return r.res.ZeroCopyWrite(data)
+}
+
+// UnaryHandler makes an EventHandler which reads the only chunk of a request,
+// decodes it to In, calls fn and replies with the encoded result.
+// An error returned by fn is sent via ReplyError.
+func UnaryHandler[In, Out any](fn func(context.Context, In) (Out, error), opts ...TypedOption) EventHandler {
+	options := newTypedOptions(opts)
+	return func(ctx context.Context, req Request, res Response) {
+		in, err := (&TypedRequest[In]{req, options.codec}).Recv(ctx)
+		if err != nil {
+			replyTypedError(res, err)
+			return
+		}
+
+		out, err := fn(ctx, in)
+		if err != nil {
+			replyTypedError(res, err)
+			return
+		}
+
+		if err := (&TypedResponse[Out]{res, options.codec}).Send(out); err != nil {
+			replyTypedError(res, err)
+			return
+		}
+		res.Close()
+	}
+}
+
+// ServerStreamHandler makes an EventHandler which reads the only chunk
+// of a request and lets fn reply with a stream of chunks.
+// The response is closed when fn returns.
+func ServerStreamHandler[In, Out any](fn func(context.Context, In, *TypedResponse[Out]) error, opts ...TypedOption) EventHandler {
+	options := newTypedOptions(opts)
+	return func(ctx context.Context, req Request, res Response) {
+		in, err := (&TypedRequest[In]{req, options.codec}).Recv(ctx)
+		if err != nil {
+			replyTypedError(res, err)
+			return
+		}
+
+		if err := fn(ctx, in, &TypedResponse[Out]{res, options.codec}); err != nil {
+			replyTypedError(res, err)
+			return
+		}
+		res.Close()
+	}
+}
+
+// StreamHandler makes an EventHandler which lets fn read a stream of chunks
+// and reply with a stream of chunks.
+// The response is closed when fn returns.
+func StreamHandler[In, Out any](fn func(context.Context, *TypedRequest[In], *TypedResponse[Out]) error, opts ...TypedOption) EventHandler {
+	options := newTypedOptions(opts)
+	return func(ctx context.Context, req Request, res Response) {
+		err := fn(ctx,
+			&TypedRequest[In]{req, options.codec},
+			&TypedResponse[Out]{res, options.codec})
+		if err != nil {
+			replyTypedError(res, err)
+			return
+		}
+		res.Close()
+	}
+}
+
+// OnTyped registers a unary typed handler of the event
+// on a Worker or EventHandlers
+func OnTyped[In, Out any](w interface{ On(string, EventHandler) }, event string, fn func(context.Context, In) (Out, error), opts ...TypedOption) {
+	w.On(event, UnaryHandler(fn, opts...))
+}
+
+type typedDecodeError struct {
+	err error
+}
+
+func (e *typedDecodeError) Error() string {
+	return fmt.Sprintf("unable to decode a chunk: %v", e.err)
+}
+
+func (e *typedDecodeError) Unwrap() error {
+	return e.err
+}
+
+// replyTypedError sends the error to a client. Malformed requests
+// are reported as framework errors with ErrorBadRequest,
+// others are sent by ReplyError.
+func replyTypedError(res Response, err error) {
+	var decodeErr *typedDecodeError
+
+	switch {
+	case errors.As(err, &decodeErr), err == ErrStreamIsClosed, err == ErrBadPayload:
+		res.ErrorMsg(ErrorBadRequest, err.Error())
+	default:
+		ReplyError(res, err)
+	}
+}
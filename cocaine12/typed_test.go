@@ -0,0 +1,139 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSender struct {
+	messages []*Message
+}
+
+func (s *testSender) Send(msg *Message) {
+	s.messages = append(s.messages, msg)
+}
+
+func (s *testSender) SendContext(ctx context.Context, msg *Message) error {
+	s.Send(msg)
+	return nil
+}
+
+type sumRequest struct {
+	A, B int
+}
+
+type sumResult struct {
+	Sum int
+}
+
+func callTyped(t *testing.T, handler EventHandler, c Codec, chunks ...interface{}) []*Message {
+	req := newRequest(newV1Protocol(), DefaultWatermarks())
+	for _, chunk := range chunks {
+		data, err := c.Marshal(chunk)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		req.push(newChunkV1(1, data))
+	}
+	req.Close()
+
+	sender := &testSender{}
+	handler(context.Background(), req, newResponse(context.Background(), newV1Protocol(), 1, sender, nil))
+	return sender.messages
+}
+
+func TestUnaryHandler(t *testing.T) {
+	sum := func(ctx context.Context, in sumRequest) (sumResult, error) {
+		if in.A < 0 {
+			return sumResult{}, errors.New("negative")
+		}
+		if in.B < 0 {
+			return sumResult{}, &ErrRequest{Message: "bad b", Code: 42}
+		}
+		return sumResult{in.A + in.B}, nil
+	}
+
+	for _, c := range []Codec{MsgpackCodec, JSONCodec} {
+		handler := UnaryHandler(sum, WithCodec(c))
+
+		messages := callTyped(t, handler, c, sumRequest{1, 2})
+		if assert.Len(t, messages, 2) {
+			assert.Equal(t, uint64(v1Write), messages[0].MsgType)
+			var res sumResult
+			assert.NoError(t, c.Unmarshal(messages[0].Payload[0].([]byte), &res))
+			assert.Equal(t, 3, res.Sum)
+			assert.Equal(t, uint64(v1Close), messages[1].MsgType)
+		}
+
+		for in, code := range map[sumRequest]int{
+			{-1, 0}: ErrorHandlerFailed,
+			{0, -1}: 42,
+		} {
+			messages = callTyped(t, handler, c, in)
+			if assert.Len(t, messages, 1) {
+				err := decodeErrorMessage(messages[0])
+				assert.Equal(t, code, err.(*ErrRequest).Code)
+				assert.ErrorIs(t, err, ErrApplicationError)
+			}
+		}
+
+		// no chunks at all
+		messages = callTyped(t, handler, c)
+		if assert.Len(t, messages, 1) {
+			err := decodeErrorMessage(messages[0])
+			assert.Equal(t, ErrorBadRequest, err.(*ErrRequest).Code)
+			assert.ErrorIs(t, err, ErrFrameworkError)
+		}
+	}
+
+	// malformed chunk
+	messages := callTyped(t, UnaryHandler(sum), JSONCodec, "not a struct")
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, ErrorBadRequest, decodeErrorMessage(messages[0]).(*ErrRequest).Code)
+	}
+}
+
+func TestStreamHandlers(t *testing.T) {
+	count := ServerStreamHandler(func(ctx context.Context, n int, res *TypedResponse[int]) error {
+		for i := 0; i < n; i++ {
+			if err := res.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	messages := callTyped(t, count, MsgpackCodec, 3)
+	if assert.Len(t, messages, 4) {
+		for i := 0; i < 3; i++ {
+			var n int
+			assert.NoError(t, MsgpackCodec.Unmarshal(messages[i].Payload[0].([]byte), &n))
+			assert.Equal(t, i, n)
+		}
+		assert.Equal(t, uint64(v1Close), messages[3].MsgType)
+	}
+
+	total := StreamHandler(func(ctx context.Context, req *TypedRequest[int], res *TypedResponse[int]) error {
+		var sum int
+		for {
+			n, err := req.Recv(ctx)
+			if err == ErrStreamIsClosed {
+				return res.Send(sum)
+			} else if err != nil {
+				return err
+			}
+			sum += n
+		}
+	})
+
+	messages = callTyped(t, total, MsgpackCodec, 1, 2, 3)
+	if assert.Len(t, messages, 2) {
+		var sum int
+		assert.NoError(t, MsgpackCodec.Unmarshal(messages[0].Payload[0].([]byte), &sum))
+		assert.Equal(t, 6, sum)
+		assert.Equal(t, uint64(v1Close), messages[1].MsgType)
+	}
+}
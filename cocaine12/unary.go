@@ -0,0 +1,48 @@
+package cocaine12
+
+import (
+	"context"
+	"fmt"
+)
+
+// CallOne calls a one-shot method, which replies with a single value
+// or error, and decodes the value into out. A value of a single item
+// is decoded itself, a tuple of several items is decoded as an array.
+// An error frame is returned as *ErrRequest. The session is detached
+// when CallOne returns. out may be nil to drop the value.
+func (service *Service) CallOne(ctx context.Context, method string, out interface{}, args ...interface{}) error {
+	if !service.isOneShot(method) {
+		return fmt.Errorf("%s is not a one-shot method of %s", method, service.name)
+	}
+
+	ch, err := service.Call(ctx, method, args...)
+	if err != nil {
+		return err
+	}
+	defer ch.Cancel()
+
+	res, err := ch.Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if res.payloadLen() == 1 {
+		return res.ExtractTuple(out)
+	}
+	return res.Extract(out)
+}
+
+// CallOne is a typed variant of Service.CallOne
+func CallOne[Out any](ctx context.Context, service *Service, method string, args ...interface{}) (Out, error) {
+	var out Out
+	err := service.CallOne(ctx, method, &out, args...)
+	return out, err
+}
@@ -0,0 +1,69 @@
+package cocaine12_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallOne(t *testing.T) {
+	loc, err := cocainetest.NewLocator()
+	if err != nil {
+		t.Fatalf("unable to start locator: %v", err)
+	}
+	defer loc.Close()
+
+	backend, err := cocainetest.NewService()
+	if err != nil {
+		t.Fatalf("unable to start service: %v", err)
+	}
+	defer backend.Close()
+
+	backend.Handle(cocainetest.PrimitiveMethod("echo"), func(call cocainetest.Call) []cocainetest.Reply {
+		return []cocainetest.Reply{cocainetest.Value(call.Args...)}
+	})
+	backend.Script(cocainetest.PrimitiveMethod("fail"), cocainetest.Error(1, 5, "boom"))
+	backend.Script(cocainetest.StreamingMethod("watch"), cocainetest.Chunk(0))
+	loc.Add("backend", backend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := cocaine.NewService(ctx, "backend", loc.Endpoints())
+	if err != nil {
+		t.Fatalf("unable to create service: %v", err)
+	}
+	defer s.Close()
+
+	var n int
+	assert.NoError(t, s.CallOne(ctx, "echo", &n, 42))
+	assert.Equal(t, 42, n)
+
+	// a tuple is decoded as an array
+	var pair struct {
+		Name  string
+		Count int
+	}
+	assert.NoError(t, s.CallOne(ctx, "echo", &pair, "apples", 3))
+	assert.Equal(t, "apples", pair.Name)
+	assert.Equal(t, 3, pair.Count)
+
+	assert.NoError(t, s.CallOne(ctx, "echo", nil, 1))
+
+	name, err := cocaine.CallOne[string](ctx, s, "echo", "pears")
+	assert.NoError(t, err)
+	assert.Equal(t, "pears", name)
+
+	_, err = cocaine.CallOne[int](ctx, s, "fail")
+	if assert.IsType(t, &cocaine.ErrRequest{}, err) {
+		assert.Equal(t, 5, err.(*cocaine.ErrRequest).Code)
+	}
+
+	assert.Error(t, s.CallOne(ctx, "watch", nil))
+	assert.Error(t, s.CallOne(ctx, "missing", nil))
+	assert.Equal(t, 0, s.OpenSessions())
+}
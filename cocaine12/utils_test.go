@@ -24,7 +24,7 @@ func TestRequestReaderEOF(t *testing.T) {
 		{"D", 102},
 	}
 
-	req := newRequest(newV1Protocol())
+	req := newRequest(newV1Protocol(), DefaultWatermarks())
 	for _, m := range chunks {
 		body, _ := json.Marshal(m)
 		req.push(newChunkV1(2, body))
@@ -58,7 +58,7 @@ func TestRequestReaderErrorV1(t *testing.T) {
 		{"D", 102},
 	}
 
-	req := newRequest(newV1Protocol())
+	req := newRequest(newV1Protocol(), DefaultWatermarks())
 	for _, m := range chunks {
 		body, _ := json.Marshal(m)
 		req.push(newChunkV1(2, body))
@@ -93,3 +93,13 @@ func TestServiceResult(t *testing.T) {
 	assert.Equal(t, "A", s)
 	assert.Equal(t, 100, i)
 }
+
+func TestServiceResultHeaders(t *testing.T) {
+	sr := serviceRes{
+		headers: newCocaineHeaders([]HeaderField{{"X-Request-Id", []byte("abc")}}),
+	}
+	assert.Equal(t, []HeaderField{{"X-Request-Id", []byte("abc")}}, sr.Headers())
+
+	empty := serviceRes{}
+	assert.Empty(t, empty.Headers())
+}
@@ -0,0 +1,83 @@
+package cocaine12
+
+import (
+	"fmt"
+)
+
+// Watermarks bound a buffer of messages by their number and size.
+// Once the buffer reaches a high watermark it stops taking messages,
+// which blocks the sender, until it drains to the low watermarks.
+// A zero high watermark means no limit.
+type Watermarks struct {
+	HighMessages int
+	LowMessages  int
+	HighBytes    int
+	LowBytes     int
+}
+
+// DefaultWatermarks bound a buffer by 4096 messages or 16MiB
+// and let it take messages again at the half of them.
+// They aren't applied unless passed to WithWatermarks.
+func DefaultWatermarks() Watermarks {
+	return Watermarks{
+		HighMessages: 4096,
+		LowMessages:  2048,
+		HighBytes:    16 << 20,
+		LowBytes:     8 << 20,
+	}
+}
+
+func (w Watermarks) validate() error {
+	if w.HighMessages < 0 || w.LowMessages < 0 || w.HighBytes < 0 || w.LowBytes < 0 {
+		return fmt.Errorf("watermarks must not be negative, got %+v", w)
+	}
+	if w.HighMessages > 0 && w.LowMessages > w.HighMessages {
+		return fmt.Errorf("low watermark %d exceeds high watermark %d of messages",
+			w.LowMessages, w.HighMessages)
+	}
+	if w.HighBytes > 0 && w.LowBytes > w.HighBytes {
+		return fmt.Errorf("low watermark %d exceeds high watermark %d of bytes",
+			w.LowBytes, w.HighBytes)
+	}
+	return nil
+}
+
+// bufferLevel tracks the fill of a buffer against the watermarks.
+// It's owned by the goroutine of the buffer.
+type bufferLevel struct {
+	Watermarks
+	messages int
+	bytes    int
+	paused   bool
+}
+
+func (l *bufferLevel) push(msg *Message) {
+	l.messages++
+	l.bytes += msg.size()
+}
+
+func (l *bufferLevel) pop(msg *Message) {
+	l.messages--
+	l.bytes -= msg.size()
+}
+
+// accepting tells if the buffer takes messages. It pauses the buffer
+// at a high watermark and resumes it when both low watermarks are passed.
+func (l *bufferLevel) accepting() bool {
+	if l.paused {
+		l.paused = !l.drained()
+	} else {
+		l.paused = l.full()
+	}
+	return !l.paused
+}
+
+func (l *bufferLevel) full() bool {
+	return (l.HighMessages > 0 && l.messages >= l.HighMessages) ||
+		(l.HighBytes > 0 && l.bytes >= l.HighBytes)
+}
+
+func (l *bufferLevel) drained() bool {
+	return (l.HighMessages == 0 || l.messages <= l.LowMessages) &&
+		(l.HighBytes == 0 || l.bytes <= l.LowBytes)
+}
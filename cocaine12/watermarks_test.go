@@ -0,0 +1,266 @@
+package cocaine12
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sent(ch chan<- *Message, msg *Message) bool {
+	select {
+	case ch <- msg:
+		return true
+	case <-time.After(20 * time.Millisecond):
+		return false
+	}
+}
+
+func TestAsyncBuffWatermarks(t *testing.T) {
+	buf := newAsyncBuf(Watermarks{HighMessages: 2, LowMessages: 1})
+	defer buf.Stop()
+
+	msg := newChunkV1(1, []byte("chunk"))
+	assert.True(t, sent(buf.in, msg))
+	assert.True(t, sent(buf.in, msg))
+	// the high watermark is hit
+	assert.False(t, sent(buf.in, msg))
+
+	<-buf.out
+	// the low watermark is passed
+	assert.True(t, sent(buf.in, msg))
+
+	bytesBuf := newAsyncBuf(Watermarks{HighBytes: 10})
+	defer bytesBuf.Stop()
+
+	assert.True(t, sent(bytesBuf.in, newChunkV1(1, []byte("0123456789"))))
+	assert.False(t, sent(bytesBuf.in, msg))
+	<-bytesBuf.out
+	assert.True(t, sent(bytesBuf.in, msg))
+
+	assert.Error(t, Watermarks{HighMessages: 1, LowMessages: 2}.validate())
+	assert.Error(t, Watermarks{HighBytes: -1}.validate())
+	assert.NoError(t, DefaultWatermarks().validate())
+}
+
+func TestRequestWatermarks(t *testing.T) {
+	req := newRequest(newV1Protocol(), Watermarks{HighMessages: 1})
+
+	msg := newChunkV1(1, []byte("chunk"))
+	assert.True(t, sent(req.fromWorker, msg))
+	assert.False(t, sent(req.fromWorker, msg))
+
+	data, err := req.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("chunk"), data)
+	assert.True(t, sent(req.fromWorker, msg))
+
+	// push doesn't block once the handler is over
+	req.discard()
+	done := make(chan struct{})
+	go func() {
+		req.push(msg)
+		req.push(msg)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("push is blocked after discard")
+	}
+}
+
+// stuckConn blocks writes until it's closed
+type stuckConn struct {
+	closed chan struct{}
+}
+
+func (c *stuckConn) Read(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *stuckConn) Write(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (c *stuckConn) Close() error {
+	return nil
+}
+
+func TestResponseWriteBackpressure(t *testing.T) {
+	conn := &stuckConn{closed: make(chan struct{})}
+	defer close(conn.closed)
+
+	sock, _ := newAsyncRWWithOptions(conn, defaultWireFormat{}, socketOptions{
+		watermarks: Watermarks{HighMessages: 1},
+	})
+	defer sock.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res := newResponse(ctx, newV1Protocol(), 1, sock, nil)
+	// the chunks are stuck in the writeloop
+	// until the buffer reaches the high watermark
+	var (
+		written int
+		err     error
+	)
+	for ; written < 10 && err == nil; written++ {
+		_, err = res.Write([]byte("chunk"))
+	}
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, written <= 4, "%d chunks are written", written)
+}
+
+func TestWorkerHeartbeatsAtWatermark(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true,
+		WithWatermarks(Watermarks{HighMessages: 1}),
+		WithHeartbeatTimeout(20*time.Millisecond),
+		WithDisownTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	unblock := make(chan struct{})
+	w.On("slow", func(ctx context.Context, req Request, res Response) {
+		<-unblock
+		for {
+			chunk, err := req.Read(ctx)
+			if err != nil {
+				break
+			}
+			res.Write(chunk)
+		}
+		res.Close()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(nil)
+	}()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	// the handler is at the watermark after the first chunk,
+	// the second one is parked and the rest is not read
+	sock2.Write() <- newInvokeV1(2, "slow")
+	for _, chunk := range []string{"A", "B", "C", "D"} {
+		sock2.Write() <- newChunkV1(2, []byte(chunk))
+	}
+	sock2.Write() <- newChokeV1(2)
+
+	// the heartbeats keep going without the replies
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-sock2.Read():
+			checkTypeAndSession(t, msg, v1UtilitySession, v1Heartbeat)
+		case err := <-done:
+			t.Fatalf("the worker has stopped: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("no heartbeat")
+		}
+	}
+
+	close(unblock)
+	var chunks []string
+	for {
+		msg := <-sock2.Read()
+		if msg.Session == v1UtilitySession {
+			continue
+		}
+		if msg.MsgType == v1Close {
+			break
+		}
+		checkTypeAndSession(t, msg, 2, v1Write)
+		chunk, _ := msg.payloadBytes()
+		chunks = append(chunks, string(chunk))
+	}
+	assert.Equal(t, []string{"A", "B", "C", "D"}, chunks)
+}
+
+func TestWorkerSlowSession(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	// no watermarks by default
+	w, err := newWorker(sock, "uuid", 1, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	w.On("slow", func(ctx context.Context, req Request, res Response) {
+		<-unblock
+		res.Close()
+	})
+	w.On("echo", func(ctx context.Context, req Request, res Response) {
+		chunk, _ := req.Read(ctx)
+		res.Write(chunk)
+		res.Close()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Run(nil)
+	}()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	// the slow handler reads nothing, its chunks pile up
+	// beyond DefaultWatermarks without blocking the worker
+	sock2.Write() <- newInvokeV1(2, "slow")
+	for i := 0; i < DefaultWatermarks().HighMessages+1; i++ {
+		sock2.Write() <- newChunkV1(2, []byte("chunk"))
+	}
+
+	sock2.Write() <- newInvokeV1(3, "echo")
+	sock2.Write() <- newChunkV1(3, []byte("A"))
+	sock2.Write() <- newChokeV1(3)
+
+	for _, msgType := range []uint64{v1Write, v1Close} {
+		var msg *Message
+		for msg == nil || msg.Session == v1UtilitySession {
+			select {
+			case msg = <-sock2.Read():
+			case <-time.After(time.Second):
+				t.Fatal("the other session is blocked")
+			}
+		}
+		checkTypeAndSession(t, msg, 3, msgType)
+	}
+
+	sock2.Write() <- &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: v1UtilitySession,
+			MsgType: v1Terminate,
+		},
+		Payload: []interface{}{100, "TestTermination"},
+	}
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the termination is blocked")
+	}
+}
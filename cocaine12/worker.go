@@ -9,9 +9,10 @@ type Worker struct {
 	terminationHandler TerminationHandler
 }
 
-// NewWorker connects to the cocaine-runtime and create WorkerNG on top of this connection
-func NewWorker() (*Worker, error) {
-	impl, err := NewWorkerNG()
+// NewWorker connects to the cocaine-runtime and create WorkerNG on top of this connection.
+// Options are applied on top of the values provided by DefaultValues.
+func NewWorker(opts ...WorkerOption) (*Worker, error) {
+	impl, err := NewWorkerNG(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -19,8 +20,13 @@ func NewWorker() (*Worker, error) {
 }
 
 // Used in tests only
-func newWorker(conn socketIO, id string, protoVersion int, debug bool) (*Worker, error) {
-	impl, err := newWorkerNG(conn, id, protoVersion, debug, new(NullTokenManager))
+func newWorker(conn socketIO, id string, protoVersion int, debug bool, opts ...WorkerOption) (*Worker, error) {
+	options, err := newWorkerOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	impl, err := newWorkerNG(conn, id, protoVersion, debug, new(NullTokenManager), options)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +51,11 @@ func (w *Worker) Token() Token {
 	return w.impl.Token()
 }
 
+// Timeouts returns the timeouts the worker uses
+func (w *Worker) Timeouts() WorkerTimeouts {
+	return w.impl.Timeouts()
+}
+
 // SetTerminationHandler allows to attach handler which will be called
 // when SIGTERM arrives
 func (w *Worker) SetTerminationHandler(handler TerminationHandler) {
@@ -61,6 +72,18 @@ func (w *Worker) SetFallbackHandler(handler FallbackEventHandler) {
 	w.handlers.SetFallbackHandler(RequestHandler(handler))
 }
 
+// Use appends middlewares applied to every event.
+// It must be called before Worker.Run
+func (w *Worker) Use(middlewares ...Middleware) {
+	w.handlers.Use(middlewares...)
+}
+
+// UseOn appends middlewares applied to the given event only.
+// It must be called before Worker.Run
+func (w *Worker) UseOn(event string, middlewares ...Middleware) {
+	w.handlers.UseOn(event, middlewares...)
+}
+
 func (w *Worker) Run(handlers map[string]EventHandler) error {
 	for event, handler := range handlers {
 		w.On(event, handler)
@@ -72,3 +95,8 @@ func (w *Worker) Run(handlers map[string]EventHandler) error {
 func (w *Worker) Stop() {
 	w.impl.Stop()
 }
+
+// FlushStats returns the counters of the flushes to cocaine-runtime
+func (w *Worker) FlushStats() FlushStats {
+	return w.impl.FlushStats()
+}
@@ -18,13 +18,24 @@ type TerminationHandler func(context.Context)
 // for the given event
 type FallbackEventHandler RequestHandler
 
+// Middleware wraps a handler to add common behaviour
+// like logging, timing or authorization
+type Middleware func(RequestHandler) RequestHandler
+
 type EventHandlers struct {
 	fallback RequestHandler
 	handlers map[string]EventHandler
+
+	middlewares      []Middleware
+	eventMiddlewares map[string][]Middleware
 }
 
 func NewEventHandlersFromMap(handlers map[string]EventHandler) *EventHandlers {
-	return &EventHandlers{DefaultFallbackHandler, handlers}
+	return &EventHandlers{
+		fallback:         DefaultFallbackHandler,
+		handlers:         handlers,
+		eventMiddlewares: make(map[string][]Middleware),
+	}
 }
 
 func NewEventHandlers() *EventHandlers {
@@ -40,6 +51,20 @@ func (e *EventHandlers) SetFallbackHandler(handler RequestHandler) {
 	e.fallback = handler
 }
 
+// Use appends middlewares applied to every event including
+// the ones handled by the fallback handler.
+// A middleware added earlier wraps the ones added later,
+// global middlewares wrap per-event ones.
+func (e *EventHandlers) Use(middlewares ...Middleware) {
+	e.middlewares = append(e.middlewares, middlewares...)
+}
+
+// UseOn appends middlewares applied to the given event only.
+// They wrap the fallback handler if the event has no handler.
+func (e *EventHandlers) UseOn(event string, middlewares ...Middleware) {
+	e.eventMiddlewares[event] = append(e.eventMiddlewares[event], middlewares...)
+}
+
 // DefaultFallbackHandler sends an error message if a client requests
 // unhandled event
 func DefaultFallbackHandler(ctx context.Context, event string, request Request, response Response) {
@@ -48,10 +73,21 @@ func DefaultFallbackHandler(ctx context.Context, event string, request Request,
 }
 
 func (e *EventHandlers) Call(ctx context.Context, event string, request Request, response Response) {
-	handler := e.handlers[event]
-	if handler == nil {
-		e.fallback(ctx, event, request, response)
-		return
+	var handler = e.fallback
+	if eventHandler := e.handlers[event]; eventHandler != nil {
+		handler = func(ctx context.Context, event string, request Request, response Response) {
+			eventHandler(ctx, request, response)
+		}
+	}
+
+	handler = chainMiddlewares(handler, e.eventMiddlewares[event])
+	handler = chainMiddlewares(handler, e.middlewares)
+	handler(ctx, event, request, response)
+}
+
+func chainMiddlewares(handler RequestHandler, middlewares []Middleware) RequestHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
 	}
-	handler(ctx, request, response)
+	return handler
 }
@@ -0,0 +1,46 @@
+package cocaine12
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHandlersMiddlewares(t *testing.T) {
+	var trace []string
+
+	record := func(name string) Middleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(ctx context.Context, event string, req Request, res Response) {
+				trace = append(trace, name+":"+event)
+				next(ctx, event, req, res)
+			}
+		}
+	}
+
+	handlers := NewEventHandlers()
+	handlers.On("echo", func(ctx context.Context, req Request, res Response) {
+		trace = append(trace, "handler")
+	})
+	handlers.SetFallbackHandler(func(ctx context.Context, event string, req Request, res Response) {
+		trace = append(trace, "fallback")
+	})
+
+	handlers.Use(record("global1"), record("global2"))
+	handlers.UseOn("echo", record("echo"))
+	handlers.UseOn("missing", record("missing"))
+
+	ctx := context.Background()
+
+	handlers.Call(ctx, "echo", nil, nil)
+	assert.Equal(t, []string{"global1:echo", "global2:echo", "echo:echo", "handler"}, trace)
+
+	trace = nil
+	handlers.Call(ctx, "unknown", nil, nil)
+	assert.Equal(t, []string{"global1:unknown", "global2:unknown", "fallback"}, trace)
+
+	trace = nil
+	handlers.Call(ctx, "missing", nil, nil)
+	assert.Equal(t, []string{"global1:missing", "global2:missing", "missing:missing", "fallback"}, trace)
+}
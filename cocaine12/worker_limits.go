@@ -0,0 +1,132 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTooManySessions means that the limit of concurrent sessions
+	// is hit and the wait queue is full
+	ErrTooManySessions = errors.New("too many concurrent sessions")
+	// ErrQueueTimeout means that a session has been waiting
+	// for a free slot longer than the queue timeout
+	ErrQueueTimeout = errors.New("timed out waiting in the session queue")
+)
+
+// sessionLimiter bounds the number of concurrent handlers
+// globally and per event. Sessions which do not fit into
+// the limits wait in a bounded queue.
+type sessionLimiter struct {
+	// nil channel means no limit
+	global chan struct{}
+	events map[string]chan struct{}
+
+	// each waiting session occupies a slot
+	queue        chan struct{}
+	queueTimeout time.Duration
+}
+
+func newSessionLimiter(o *workerOptions) *sessionLimiter {
+	l := &sessionLimiter{
+		events:       make(map[string]chan struct{}, len(o.maxEventSessions)),
+		queue:        make(chan struct{}, o.queueSize),
+		queueTimeout: o.queueTimeout,
+	}
+
+	if o.maxSessions > 0 {
+		l.global = make(chan struct{}, o.maxSessions)
+	}
+
+	for event, limit := range o.maxEventSessions {
+		if limit > 0 {
+			l.events[event] = make(chan struct{}, limit)
+		}
+	}
+
+	return l
+}
+
+// acquire takes a slot for the event. The returned function
+// must be called to free the slot once the session is over.
+func (l *sessionLimiter) acquire(ctx context.Context, event string) (func(), error) {
+	var (
+		eventSlots = l.events[event]
+		release    = func() {
+			if eventSlots != nil {
+				<-eventSlots
+			}
+			if l.global != nil {
+				<-l.global
+			}
+		}
+	)
+
+	// fast path: both slots are free
+	if l.tryAcquire(eventSlots) {
+		if l.tryAcquire(l.global) {
+			return release, nil
+		}
+		l.releaseSlot(eventSlots)
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+		defer func() { <-l.queue }()
+	default:
+		return nil, ErrTooManySessions
+	}
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	if err := l.wait(ctx, eventSlots, timeout); err != nil {
+		return nil, err
+	}
+
+	if err := l.wait(ctx, l.global, timeout); err != nil {
+		l.releaseSlot(eventSlots)
+		return nil, err
+	}
+
+	return release, nil
+}
+
+func (l *sessionLimiter) tryAcquire(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *sessionLimiter) releaseSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+func (l *sessionLimiter) wait(ctx context.Context, slots chan struct{}, timeout <-chan time.Time) error {
+	if slots == nil {
+		return nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
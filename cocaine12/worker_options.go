@@ -0,0 +1,281 @@
+package cocaine12
+
+import (
+	"fmt"
+	"time"
+)
+
+// WorkerTimeouts describes the timeouts used by WorkerNG
+// to communicate with cocaine-runtime
+type WorkerTimeouts struct {
+	// Heartbeat is an interval between two heartbeats sent to the runtime
+	Heartbeat time.Duration
+	// Disown is a time to wait for a reply to a heartbeat
+	// before the worker treats itself as disowned
+	Disown time.Duration
+	// CoreConnection limits the time to connect to the runtime
+	CoreConnection time.Duration
+	// Termination limits the time given to TerminationHandler
+	Termination time.Duration
+}
+
+// DefaultWorkerTimeouts returns the timeouts used when nothing is overridden
+func DefaultWorkerTimeouts() WorkerTimeouts {
+	return WorkerTimeouts{
+		Heartbeat:      heartbeatTimeout,
+		Disown:         disownTimeout,
+		CoreConnection: coreConnectionTimeout,
+		Termination:    terminationTimeout,
+	}
+}
+
+func (t WorkerTimeouts) validate() error {
+	switch {
+	case t.Heartbeat <= 0:
+		return fmt.Errorf("heartbeat timeout must be positive, got %v", t.Heartbeat)
+	case t.Disown <= 0:
+		return fmt.Errorf("disown timeout must be positive, got %v", t.Disown)
+	case t.CoreConnection <= 0:
+		return fmt.Errorf("core connection timeout must be positive, got %v", t.CoreConnection)
+	case t.Termination <= 0:
+		return fmt.Errorf("termination timeout must be positive, got %v", t.Termination)
+	case t.Disown > t.Heartbeat:
+		// the disown timer is rearmed by every heartbeat,
+		// so it would never fire
+		return fmt.Errorf("disown timeout %v must not exceed heartbeat timeout %v",
+			t.Disown, t.Heartbeat)
+	}
+	return nil
+}
+
+func (t WorkerTimeouts) String() string {
+	return fmt.Sprintf("heartbeat %v, disown %v, core connection %v, termination %v",
+		t.Heartbeat, t.Disown, t.CoreConnection, t.Termination)
+}
+
+type workerOptions struct {
+	// where and how to connect to cocaine-runtime,
+	// NewWorkerNG takes them from DefaultValues unless overridden
+	endpoint     string
+	uuid         string
+	protoVersion int
+	appName      string
+
+	timeouts     WorkerTimeouts
+	drainTimeout time.Duration
+	drainHook    func(DrainResult)
+
+	maxSessions      int
+	maxEventSessions map[string]int
+	queueSize        int
+	queueTimeout     time.Duration
+
+	batching   WriteBatching
+	watermarks Watermarks
+}
+
+// WorkerOption configures WorkerNG and Worker
+type WorkerOption func(*workerOptions)
+
+func newWorkerOptions(opts []WorkerOption) (*workerOptions, error) {
+	options := &workerOptions{
+		timeouts:         DefaultWorkerTimeouts(),
+		maxEventSessions: make(map[string]int),
+		batching:         DefaultWriteBatching(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if err := options.timeouts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid worker options: %v", err)
+	}
+
+	if options.drainTimeout < 0 {
+		return nil, fmt.Errorf("invalid worker options: drain timeout must not be negative, got %v",
+			options.drainTimeout)
+	}
+
+	if err := options.validateLimits(); err != nil {
+		return nil, fmt.Errorf("invalid worker options: %v", err)
+	}
+
+	if err := options.batching.validate(); err != nil {
+		return nil, fmt.Errorf("invalid worker options: %v", err)
+	}
+
+	if err := options.watermarks.validate(); err != nil {
+		return nil, fmt.Errorf("invalid worker options: %v", err)
+	}
+
+	return options, nil
+}
+
+func (o *workerOptions) validateLimits() error {
+	if o.maxSessions < 0 {
+		return fmt.Errorf("max sessions must not be negative, got %d", o.maxSessions)
+	}
+
+	for event, limit := range o.maxEventSessions {
+		if limit < 0 {
+			return fmt.Errorf("max sessions of event %s must not be negative, got %d", event, limit)
+		}
+	}
+
+	if o.queueSize < 0 {
+		return fmt.Errorf("queue size must not be negative, got %d", o.queueSize)
+	}
+
+	if o.queueTimeout < 0 {
+		return fmt.Errorf("queue timeout must not be negative, got %v", o.queueTimeout)
+	}
+	return nil
+}
+
+// WithEndpoint sets the unix socket of cocaine-runtime
+func WithEndpoint(endpoint string) WorkerOption {
+	return func(o *workerOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithUUID sets the id the worker introduces itself with
+func WithUUID(uuid string) WorkerOption {
+	return func(o *workerOptions) {
+		o.uuid = uuid
+	}
+}
+
+// WithProtocolVersion sets the version of the worker protocol
+func WithProtocolVersion(version int) WorkerOption {
+	return func(o *workerOptions) {
+		o.protoVersion = version
+	}
+}
+
+// WithAppName sets the name of the application
+func WithAppName(name string) WorkerOption {
+	return func(o *workerOptions) {
+		o.appName = name
+	}
+}
+
+// WithTimeouts replaces all the worker timeouts at once.
+// Zero fields are left untouched.
+func WithTimeouts(timeouts WorkerTimeouts) WorkerOption {
+	return func(o *workerOptions) {
+		if timeouts.Heartbeat != 0 {
+			o.timeouts.Heartbeat = timeouts.Heartbeat
+		}
+		if timeouts.Disown != 0 {
+			o.timeouts.Disown = timeouts.Disown
+		}
+		if timeouts.CoreConnection != 0 {
+			o.timeouts.CoreConnection = timeouts.CoreConnection
+		}
+		if timeouts.Termination != 0 {
+			o.timeouts.Termination = timeouts.Termination
+		}
+	}
+}
+
+// WithHeartbeatTimeout sets an interval between heartbeats
+func WithHeartbeatTimeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.timeouts.Heartbeat = d
+	}
+}
+
+// WithDisownTimeout sets a time to wait for a heartbeat reply
+func WithDisownTimeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.timeouts.Disown = d
+	}
+}
+
+// WithCoreConnectionTimeout sets a timeout to connect to cocaine-runtime
+func WithCoreConnectionTimeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.timeouts.CoreConnection = d
+	}
+}
+
+// WithTerminationTimeout sets a time given to TerminationHandler
+func WithTerminationTimeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.timeouts.Termination = d
+	}
+}
+
+// WithDrainTimeout enables the drain mode. When the termination message
+// arrives the worker stops accepting new invokes and waits up to d
+// for the sessions in flight to close their responses before replying to it.
+// Zero disables the drain mode.
+func WithDrainTimeout(d time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.drainTimeout = d
+	}
+}
+
+// WithDrainHook sets a function called with the result of the drain
+// once it is over, e.g. to log it
+func WithDrainHook(hook func(DrainResult)) WorkerOption {
+	return func(o *workerOptions) {
+		o.drainHook = hook
+	}
+}
+
+// WithMaxSessions limits the number of handlers running concurrently.
+// Zero means no limit.
+func WithMaxSessions(n int) WorkerOption {
+	return func(o *workerOptions) {
+		o.maxSessions = n
+	}
+}
+
+// WithMaxEventSessions limits the number of handlers of the event
+// running concurrently. Zero means no limit.
+func WithMaxEventSessions(event string, n int) WorkerOption {
+	return func(o *workerOptions) {
+		o.maxEventSessions[event] = n
+	}
+}
+
+// WithSessionQueue allows up to size sessions to wait for a free slot
+// when a session limit is hit. A session is rejected with
+// ErrorWorkerOverloaded if it has been waiting longer than timeout.
+// Zero timeout makes sessions wait until they are cancelled.
+func WithSessionQueue(size int, timeout time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.queueSize = size
+		o.queueTimeout = timeout
+	}
+}
+
+// WithWriteBatching bounds the replies coalesced into a single flush
+// to cocaine-runtime. A positive MaxDelay trades latency for fewer
+// syscalls when a handler streams many small chunks.
+func WithWriteBatching(batching WriteBatching) WorkerOption {
+	return func(o *workerOptions) {
+		o.batching = batching.withDefaults()
+	}
+}
+
+// WithWatermarks bounds the buffers of the connection to cocaine-runtime
+// and the chunks pending for each handler. At a high watermark
+// Response.Write blocks until the buffer drains or the context
+// of the handler is done, and the worker stops reading chunks
+// from cocaine-runtime, so the clients are pushed back.
+// The limits are off by default, zero high watermarks disable them.
+//
+// All the sessions share the connection, so the backpressure blocks
+// the head of the line: while a handler is at its high watermark the worker
+// reads nothing, and the chunks of other sessions, the heartbeats and
+// the termination from cocaine-runtime wait until the handler catches up.
+// The disown timeout is deferred meanwhile.
+func WithWatermarks(watermarks Watermarks) WorkerOption {
+	return func(o *workerOptions) {
+		o.watermarks = watermarks
+	}
+}
@@ -2,6 +2,8 @@ package cocaine12
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 )
 
 const (
@@ -9,52 +11,173 @@ const (
 	v1 = 1
 )
 
+const (
+	// ErrorCategoryFramework is the category of errors sent by the framework:
+	// no handler, panic, overload and so on. ErrorMsg sends errors
+	// of this category as well.
+	ErrorCategoryFramework = cworkererrorcategory
+	// ErrorCategoryApplication is the category of errors returned by handlers
+	ErrorCategoryApplication = 43
+)
+
+var (
+	// ErrFrameworkError matches with errors.Is any ErrRequest of ErrorCategoryFramework
+	ErrFrameworkError = &ErrRequest{Message: "framework error", Category: ErrorCategoryFramework}
+	// ErrApplicationError matches with errors.Is any ErrRequest of ErrorCategoryApplication
+	ErrApplicationError = &ErrRequest{Message: "application error", Category: ErrorCategoryApplication}
+)
+
+// ErrRequest is an error sent to a client as [category, code] and a message.
+// Handlers can return it to choose the category and the code.
 type ErrRequest struct {
 	Message        string
 	Category, Code int
 }
 
+// NewApplicationError makes an error of ErrorCategoryApplication
+func NewApplicationError(code int, message string) error {
+	return &ErrRequest{
+		Message:  message,
+		Category: ErrorCategoryApplication,
+		Code:     code,
+	}
+}
+
 func (e *ErrRequest) Error() string {
 	return fmt.Sprintf("[%d] [%d] %s", e.Category, e.Code, e.Message)
 }
 
-type messageTypeDetector interface {
-	isChunk(msg *Message) bool
+// Is reports whether the target ErrRequest has the same category.
+// The codes are compared as well unless the code of the target is zero.
+func (e *ErrRequest) Is(target error) bool {
+	t, ok := target.(*ErrRequest)
+	if !ok {
+		return false
+	}
+	return e.Category == t.Category && (t.Code == 0 || e.Code == t.Code)
 }
 
-type protocolHandler interface {
-	onChoke(msg *Message)
-	onChunk(msg *Message)
-	onError(msg *Message)
-	onHeartbeat(msg *Message)
-	onInvoke(msg *Message) error
-	onTerminate(msg *Message)
+// MessageTypeDetector recognizes messages sent by a client to a handler
+type MessageTypeDetector interface {
+	// IsChunk reports whether the message carries a chunk of data
+	IsChunk(msg *Message) bool
+	// DecodeError unpacks an error message sent by a client
+	DecodeError(msg *Message) error
 }
 
-type utilityProtocolGenerator interface {
-	newHandshake(id string) *Message
-	newHeartbeat() *Message
+// ProtocolHandler handles messages dispatched by a ProtocolDispatcher.
+// It's implemented by the worker.
+type ProtocolHandler interface {
+	OnChoke(msg *Message)
+	OnChunk(msg *Message)
+	OnError(msg *Message)
+	OnHeartbeat(msg *Message)
+	OnInvoke(msg *Message) error
+	OnTerminate(msg *Message)
 }
 
-type handlerProtocolGenerator interface {
-	messageTypeDetector
-	newChoke(session uint64) *Message
-	newChunk(session uint64, data []byte) *Message
-	newError(session uint64, category, code int, message string) *Message
+// UtilityMessageGenerator creates messages the worker uses
+// to communicate with cocaine-runtime
+type UtilityMessageGenerator interface {
+	NewHandshake(id string) *Message
+	NewHeartbeat() *Message
 }
 
-type protocolDispather interface {
-	utilityProtocolGenerator
-	handlerProtocolGenerator
-	onMessage(p protocolHandler, msg *Message) error
+// HandlerMessageGenerator creates messages a handler replies with
+type HandlerMessageGenerator interface {
+	MessageTypeDetector
+	NewChoke(session uint64) *Message
+	NewChunk(session uint64, data []byte) *Message
+	NewError(session uint64, category, code int, message string) *Message
 }
 
-func getEventName(msg *Message) (string, bool) {
-	switch event := msg.Payload[0].(type) {
-	case string:
-		return event, true
-	case []uint8:
-		return string(event), true
+// ProtocolDispatcher implements a worker protocol. It generates messages
+// and dispatches the incoming ones to a ProtocolHandler.
+// OnMessage is called from the worker loop only, so it doesn't
+// have to be goroutine safe. Message generators are called from handlers.
+// The incoming messages keep Payload and Headers encoded,
+// use DecodePayload and HeaderFields to read them.
+type ProtocolDispatcher interface {
+	UtilityMessageGenerator
+	HandlerMessageGenerator
+	OnMessage(p ProtocolHandler, msg *Message) error
+}
+
+// ProtocolFactory creates a ProtocolDispatcher for a new worker
+type ProtocolFactory func() ProtocolDispatcher
+
+var (
+	protoMu   sync.RWMutex
+	protocols = make(map[int]ProtocolFactory)
+)
+
+func init() {
+	RegisterProtocol(v0, newV0Protocol)
+	RegisterProtocol(v1, newV1Protocol)
+}
+
+// RegisterProtocol makes a worker protocol available by the provided
+// version number, which is passed by cocaine-runtime via --protocol.
+// Messages of the protocols registered outside of the package
+// are laid out as [session, type, payload, headers] on the wire.
+// If RegisterProtocol is called twice with the same version or if factory
+// is nil, it panics.
+func RegisterProtocol(version int, factory ProtocolFactory) {
+	protoMu.Lock()
+	defer protoMu.Unlock()
+
+	if factory == nil {
+		panic("cocaine: ProtocolFactory is nil")
 	}
-	return "", false
+
+	if _, dup := protocols[version]; dup {
+		panic(fmt.Sprintf("cocaine: RegisterProtocol called twice for protocol version %d", version))
+	}
+
+	protocols[version] = factory
+}
+
+// unregisterProtocol removes the version from the registry
+func unregisterProtocol(version int) {
+	protoMu.Lock()
+	defer protoMu.Unlock()
+	delete(protocols, version)
+}
+
+// Protocols returns a sorted list of the registered protocol versions
+func Protocols() []int {
+	protoMu.RLock()
+	defer protoMu.RUnlock()
+
+	var list []int
+	for version := range protocols {
+		list = append(list, version)
+	}
+	sort.Ints(list)
+	return list
+}
+
+func newProtocolDispatcher(version int) (ProtocolDispatcher, error) {
+	protoMu.RLock()
+	factory, ok := protocols[version]
+	protoMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	return factory(), nil
+}
+
+// newWireFormat returns the layout of messages on the wire
+// for the given protocol version
+func newWireFormat(protoVersion int) wireFormat {
+	if protoVersion == v0 {
+		return v0WireFormat{}
+	}
+	return defaultWireFormat{}
+}
+
+func getEventName(msg *Message) (string, bool) {
+	event, ok := msg.payloadBytes()
+	return string(event), ok
 }
@@ -0,0 +1,154 @@
+package cocaine12_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cocaine "github.com/cocaine/cocaine-framework-go/cocaine12"
+	"github.com/cocaine/cocaine-framework-go/cocaine12/cocainetest"
+	"github.com/stretchr/testify/assert"
+)
+
+const externalVersion = 2000
+
+// externalProtocol speaks the layout of the protocol v1,
+// reading the payload as a protocol outside of the package has to
+type externalProtocol struct {
+	maxSession uint64
+
+	mu     sync.Mutex
+	events []string
+	chunks []string
+}
+
+func newMessage(session, msgType uint64, payload ...interface{}) *cocaine.Message {
+	return &cocaine.Message{
+		CommonMessageInfo: cocaine.CommonMessageInfo{
+			Session: session,
+			MsgType: msgType,
+		},
+		Payload: payload,
+	}
+}
+
+func (p *externalProtocol) OnMessage(h cocaine.ProtocolHandler, msg *cocaine.Message) error {
+	if msg.Session == 1 {
+		switch msg.MsgType {
+		case 0:
+			h.OnHeartbeat(msg)
+		case 1:
+			h.OnTerminate(msg)
+		}
+		return nil
+	}
+
+	var payload []string
+	switch msg.MsgType {
+	case 0:
+		if err := msg.DecodePayload(&payload); err != nil || len(payload) == 0 {
+			return fmt.Errorf("unable to decode the payload: %v", err)
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if msg.Session > p.maxSession {
+			p.maxSession = msg.Session
+			p.events = append(p.events, payload[0])
+			return h.OnInvoke(msg)
+		}
+		p.chunks = append(p.chunks, payload[0])
+		h.OnChunk(msg)
+	case 1:
+		h.OnError(msg)
+	case 2:
+		h.OnChoke(msg)
+	}
+	return nil
+}
+
+func (p *externalProtocol) IsChunk(msg *cocaine.Message) bool {
+	return msg.MsgType == 0
+}
+
+func (p *externalProtocol) DecodeError(msg *cocaine.Message) error {
+	var payload struct {
+		Kind    [2]int
+		Message string
+	}
+	if err := msg.DecodePayload(&payload); err != nil {
+		return err
+	}
+	return errors.New(payload.Message)
+}
+
+func (p *externalProtocol) NewHandshake(id string) *cocaine.Message {
+	return newMessage(1, 0, id)
+}
+
+func (p *externalProtocol) NewHeartbeat() *cocaine.Message {
+	return newMessage(1, 0)
+}
+
+func (p *externalProtocol) NewChoke(session uint64) *cocaine.Message {
+	return newMessage(session, 2)
+}
+
+func (p *externalProtocol) NewChunk(session uint64, data []byte) *cocaine.Message {
+	return newMessage(session, 0, data)
+}
+
+func (p *externalProtocol) NewError(session uint64, category, code int, message string) *cocaine.Message {
+	return newMessage(session, 1, [2]int{category, code}, message)
+}
+
+func TestExternalProtocolPayload(t *testing.T) {
+	proto := &externalProtocol{maxSession: 1}
+	cocaine.RegisterProtocol(externalVersion, func() cocaine.ProtocolDispatcher {
+		return proto
+	})
+	t.Cleanup(func() { cocaine.UnregisterProtocol(externalVersion) })
+
+	rt, err := cocainetest.NewRuntime()
+	if err != nil {
+		t.Fatalf("unable to start runtime: %v", err)
+	}
+	defer rt.Close()
+
+	w, err := cocaine.NewWorker(append(rt.WorkerOptions(), cocaine.WithProtocolVersion(externalVersion))...)
+	if err != nil {
+		t.Fatalf("unable to create worker: %v", err)
+	}
+	defer w.Stop()
+
+	w.On("echo", func(ctx context.Context, req cocaine.Request, res cocaine.Response) {
+		for {
+			chunk, err := req.Read(ctx)
+			if err != nil {
+				break
+			}
+			res.Write(chunk)
+		}
+		res.Close()
+	})
+	go w.Run(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := rt.Invoke(ctx, "echo", []byte("A"), []byte("B"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	data, err := session.ReadAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("AB"), data)
+
+	proto.mu.Lock()
+	defer proto.mu.Unlock()
+	assert.Equal(t, []string{"echo"}, proto.events)
+	assert.Equal(t, []string{"A", "B"}, proto.chunks)
+}
@@ -0,0 +1,87 @@
+package cocaine12
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCustomProtocol struct {
+	ProtocolDispatcher
+	handshakes int
+}
+
+func (p *testCustomProtocol) NewHandshake(id string) *Message {
+	p.handshakes++
+	return p.ProtocolDispatcher.NewHandshake(id)
+}
+
+func TestRegisterProtocol(t *testing.T) {
+	const customVersion = 1000
+
+	custom := &testCustomProtocol{ProtocolDispatcher: newV1Protocol()}
+	RegisterProtocol(customVersion, func() ProtocolDispatcher {
+		return custom
+	})
+	t.Cleanup(func() { unregisterProtocol(customVersion) })
+
+	assert.Equal(t, []int{v0, v1, customVersion}, Protocols())
+	assert.Panics(t, func() { RegisterProtocol(customVersion, newV1Protocol) }, "duplicated version")
+	assert.Panics(t, func() { RegisterProtocol(customVersion+1, nil) }, "nil factory")
+
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	_, err := newWorker(sock, "uuid", customVersion+1, true)
+	assert.EqualError(t, err, "unsupported protocol version 1001")
+
+	w, err := newWorker(sock, "uuid", customVersion, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	eHandshake := <-sock2.Read()
+	checkTypeAndSession(t, eHandshake, v1UtilitySession, v1Handshake)
+	assert.Equal(t, 1, custom.handshakes)
+}
+
+func TestErrRequestCategories(t *testing.T) {
+	var err error = &ErrRequest{Message: "no handler", Category: ErrorCategoryFramework, Code: ErrorNoEventHandler}
+
+	assert.True(t, errors.Is(err, ErrFrameworkError))
+	assert.False(t, errors.Is(err, ErrApplicationError))
+	assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", err),
+		&ErrRequest{Category: ErrorCategoryFramework, Code: ErrorNoEventHandler}))
+	assert.False(t, errors.Is(err, &ErrRequest{Category: ErrorCategoryFramework, Code: ErrorPanicInHandler}))
+
+	panicErr := &ErrRequest{Message: "panic", Category: cworkererrorcategory, Code: ErrorPanicInHandler}
+	assert.False(t, errors.Is(panicErr, ErrMalformedErrorMessage))
+
+	var reqErr *ErrRequest
+	assert.True(t, errors.As(NewApplicationError(10, "boom"), &reqErr))
+	assert.Equal(t, ErrorCategoryApplication, reqErr.Category)
+	assert.Equal(t, 10, reqErr.Code)
+}
+
+func TestReplyError(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected *ErrRequest
+	}{
+		{errors.New("boom"), &ErrRequest{"boom", ErrorCategoryApplication, ErrorHandlerFailed}},
+		{&ErrRequest{"no category", 0, 5}, &ErrRequest{"no category", ErrorCategoryApplication, 5}},
+		{&ErrRequest{"custom", 1000, 6}, &ErrRequest{"custom", 1000, 6}},
+	} {
+		sender := &testSender{}
+		ReplyError(newResponse(context.Background(), newV1Protocol(), 1, sender, nil), tc.err)
+		if assert.Len(t, sender.messages, 1) {
+			assert.Equal(t, tc.expected, decodeErrorMessage(sender.messages[0]))
+		}
+	}
+}
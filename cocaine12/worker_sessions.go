@@ -0,0 +1,94 @@
+package cocaine12
+
+import (
+	"context"
+	"sync"
+)
+
+// handlerSession tracks a handler invocation
+// from the invoke message until the response is closed
+type handlerSession struct {
+	cancel context.CancelCauseFunc
+}
+
+// handlerSessions keeps sessions which responses are not closed yet.
+// Unlike WorkerNG.sessions, which is used only by the loop
+// to route incoming chunks, it's accessed from handlers goroutines.
+type handlerSessions struct {
+	sync.Mutex
+	links map[uint64]*handlerSession
+	// closed when links become empty
+	waiters []chan struct{}
+}
+
+func newHandlerSessions() *handlerSessions {
+	return &handlerSessions{
+		links: make(map[uint64]*handlerSession),
+	}
+}
+
+func (s *handlerSessions) Attach(id uint64, session *handlerSession) {
+	s.Lock()
+	s.links[id] = session
+	s.Unlock()
+}
+
+// Detach removes the session and cancels its context with the cause
+func (s *handlerSessions) Detach(id uint64, cause error) {
+	s.Lock()
+	session, ok := s.links[id]
+	delete(s.links, id)
+	if len(s.links) == 0 {
+		for _, waiter := range s.waiters {
+			close(waiter)
+		}
+		s.waiters = nil
+	}
+	s.Unlock()
+
+	if ok {
+		session.cancel(cause)
+	}
+}
+
+// Cancel cancels the context of the session with the cause,
+// but keeps it attached as its response is still open
+func (s *handlerSessions) Cancel(id uint64, cause error) {
+	s.Lock()
+	session, ok := s.links[id]
+	s.Unlock()
+
+	if ok {
+		session.cancel(cause)
+	}
+}
+
+// CancelAll cancels contexts of all sessions with the cause
+func (s *handlerSessions) CancelAll(cause error) {
+	s.Lock()
+	for _, session := range s.links {
+		session.cancel(cause)
+	}
+	s.Unlock()
+}
+
+// Drained returns a channel which is closed
+// once there are no attached sessions
+func (s *handlerSessions) Drained() <-chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+
+	waiter := make(chan struct{})
+	if len(s.links) == 0 {
+		close(waiter)
+	} else {
+		s.waiters = append(s.waiters, waiter)
+	}
+	return waiter
+}
+
+func (s *handlerSessions) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.links)
+}
@@ -9,11 +9,13 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 )
 
 const (
+	// default timeouts, they can be overridden with WorkerOption
 	heartbeatTimeout      = time.Second * 10
 	disownTimeout         = time.Second * 5
 	coreConnectionTimeout = time.Second * 5
@@ -23,6 +25,19 @@ const (
 	ErrorNoEventHandler = 200
 	// ErrorPanicInHandler returns when a handler is recovered from panic
 	ErrorPanicInHandler = 100
+	// ErrorWorkerTerminating returns when an invoke arrives
+	// after the termination message
+	ErrorWorkerTerminating = 300
+	// ErrorWorkerOverloaded returns when a session limit is hit
+	// and the session can't wait for a free slot
+	ErrorWorkerOverloaded = 400
+	// ErrorBadRequest returns when a typed handler is unable
+	// to decode a request
+	ErrorBadRequest = 500
+	// ErrorHandlerFailed returns when a typed handler fails
+	ErrorHandlerFailed = 600
+	// ErrorMalformedMessage is the code of ErrMalformedErrorMessage
+	ErrorMalformedMessage = 700
 )
 
 var (
@@ -35,13 +50,45 @@ var (
 	// ErrConnectionLost means that the connection between the worker and
 	// runtime has been lost
 	ErrConnectionLost = errors.New("the connection to runtime has been lost")
+	// ErrWorkerStopped is a cause of a handler context cancellation
+	// when the worker is stopped
+	ErrWorkerStopped = errors.New("the worker has been stopped")
+	// ErrSessionClosed is a cause of a handler context cancellation
+	// when the response of the session is closed
+	ErrSessionClosed = errors.New("the session has been closed")
+	// ErrDrainTimeout is a cause of a handler context cancellation
+	// when the session has not been finished during the drain
+	ErrDrainTimeout = errors.New("the session has not been drained in time")
 )
 
+// DrainResult describes how the sessions in flight
+// have been drained on termination
+type DrainResult struct {
+	// Finished is the number of sessions closed during the drain
+	Finished int
+	// Abandoned is the number of sessions still open after the drain timeout
+	Abandoned int
+}
+
 type requestStream interface {
 	push(*Message)
+	// queue returns the channel taking the messages of the request
+	// and the channel closed once nobody reads them
+	queue() (chan<- *Message, <-chan struct{})
 	Close()
 }
 
+// parkedMessage waits for the room in the buffer of its request.
+// The connection is not read until it's delivered to keep the order
+// of the messages, while the timers keep running.
+type parkedMessage struct {
+	msg       *Message
+	input     chan<- *Message
+	discarded <-chan struct{}
+	// called once the message is delivered or dropped
+	then func()
+}
+
 // Request provides an interface for a handler to get data
 type Request interface {
 	Read(ctx context.Context) ([]byte, error)
@@ -54,6 +101,16 @@ type ResponseStream interface {
 	// Response takes the ownership of the buffer, so provided buffer must not be edited.
 	ZeroCopyWrite(data []byte) error
 	ErrorMsg(code int, message string) error
+	// ErrorWithCategory sends an error of the category to a client
+	ErrorWithCategory(category, code int, message string) error
+
+	// ZeroCopyWriteWithHeaders sends data with the headers attached to the chunk.
+	// The protocol v0 has no headers, so they are dropped.
+	ZeroCopyWriteWithHeaders(data []byte, headers ...HeaderField) error
+	// CloseWithHeaders attaches the headers to the closing frame
+	CloseWithHeaders(headers ...HeaderField) error
+	// ErrorMsgWithHeaders attaches the headers to the error
+	ErrorMsgWithHeaders(code int, message string, headers ...HeaderField) error
 }
 
 // Response provides an interface for a handler to reply
@@ -94,10 +151,13 @@ type WorkerNG struct {
 	tokenManager TokenManager
 	// Map handlers to sessions
 	sessions map[uint64]requestStream
+	// Sessions which responses are not closed yet
+	active *handlerSessions
 	// handler
 	handler RequestHandler
 	// Notify Run about stop
-	stopped chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
 	// if set recoverTrap sends Stack
 	debug bool
 	// allow the worker to handle SIGUSR1 to print all goroutines stacks
@@ -105,48 +165,89 @@ type WorkerNG struct {
 	// protocol version id
 	protoVersion int
 	// protocol dispatcher
-	dispatcher protocolDispather
+	dispatcher ProtocolDispatcher
 	// temination handler
 	terminationHandler TerminationHandler
+	// timeouts to communicate with cocaine-runtime
+	timeouts WorkerTimeouts
+	// time to wait for the sessions in flight on termination
+	drainTimeout time.Duration
+	// set by the loop when the termination message arrives
+	terminating bool
+	// result of the last drain, written by the drain goroutine
+	drainMu     sync.Mutex
+	drainResult DrainResult
+	drainHook   func(DrainResult)
+	// limits the number of concurrent handlers
+	limiter *sessionLimiter
+	// name of the application reported to handlers
+	appName string
+	// bound the chunks pending for each handler
+	watermarks Watermarks
+	// a message for a request at its high watermark
+	parked *parkedMessage
+	// the disown timeout has come while a message was parked
+	disownDeferred bool
 }
 
-// NewWorkerNG connects to the cocaine-runtime and create WorkerNG on top of this connection
-func NewWorkerNG() (*WorkerNG, error) {
-	workerID := GetDefaults().UUID()
+// NewWorkerNG connects to the cocaine-runtime and create WorkerNG on top of this connection.
+// Options are applied on top of the values provided by DefaultValues.
+func NewWorkerNG(opts ...WorkerOption) (*WorkerNG, error) {
+	defaults := GetDefaults()
+	defaultOpts := []WorkerOption{
+		WithEndpoint(defaults.Endpoint()),
+		WithUUID(defaults.UUID()),
+		WithProtocolVersion(defaults.Protocol()),
+		WithAppName(defaults.ApplicationName()),
+	}
+	if provider, ok := defaults.(WorkerTimeoutsProvider); ok {
+		defaultOpts = append(defaultOpts, WithTimeouts(provider.WorkerTimeouts()))
+	}
 
-	unixSocketEndpoint := GetDefaults().Endpoint()
+	options, err := newWorkerOptions(append(defaultOpts, opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	unixSocketEndpoint := options.endpoint
 	if unixSocketEndpoint == "" {
 		return nil, ErrNoCocaineEndpoint
 	}
 
-	tokenManager, err := NewTokenManager(GetDefaults().ApplicationName(), GetDefaults().Token())
+	tokenManager, err := NewTokenManager(options.appName, defaults.Token())
 	if err != nil {
 		return nil, fmt.Errorf("unable to create token manager: %v", err)
 	}
 
 	// Connect to cocaine-runtime over a unix socket
-	sock, err := newUnixConnection(unixSocketEndpoint, coreConnectionTimeout)
+	sock, err := newAsyncConnectionWithFormat("unix", unixSocketEndpoint,
+		options.timeouts.CoreConnection, newWireFormat(options.protoVersion), socketOptions{
+			batching:   options.batching,
+			watermarks: options.watermarks,
+		})
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to Cocaine via %s: %v",
 			unixSocketEndpoint, err)
 	}
 
-	return newWorkerNG(sock, workerID,
-		GetDefaults().Protocol(),
-		GetDefaults().Debug(),
-		tokenManager)
+	return newWorkerNG(sock, options.uuid,
+		options.protoVersion,
+		defaults.Debug(),
+		tokenManager,
+		options)
 }
 
-func newWorkerNG(conn socketIO, id string, protoVersion int, debug bool, tokenManager TokenManager) (*WorkerNG, error) {
+func newWorkerNG(conn socketIO, id string, protoVersion int, debug bool, tokenManager TokenManager, options *workerOptions) (*WorkerNG, error) {
 	w := &WorkerNG{
 		conn: conn,
 		id:   id,
 
-		heartbeatTimer: time.NewTimer(heartbeatTimeout),
-		disownTimer:    time.NewTimer(disownTimeout),
+		heartbeatTimer: time.NewTimer(options.timeouts.Heartbeat),
+		disownTimer:    time.NewTimer(options.timeouts.Disown),
 		tokenManager:   tokenManager,
 
 		sessions: make(map[uint64]requestStream),
+		active:   newHandlerSessions(),
 
 		stopped: make(chan struct{}),
 
@@ -156,14 +257,19 @@ func newWorkerNG(conn socketIO, id string, protoVersion int, debug bool, tokenMa
 		protoVersion:       protoVersion,
 		dispatcher:         nil,
 		terminationHandler: nil,
+		timeouts:           options.timeouts,
+		drainTimeout:       options.drainTimeout,
+		drainHook:          options.drainHook,
+		limiter:            newSessionLimiter(options),
+		appName:            options.appName,
+		watermarks:         options.watermarks,
 	}
 
-	switch w.protoVersion {
-	case v1:
-		w.dispatcher = newV1Protocol()
-	default:
-		return nil, fmt.Errorf("unsupported protocol version %d", w.protoVersion)
+	dispatcher, err := newProtocolDispatcher(w.protoVersion)
+	if err != nil {
+		return nil, err
 	}
+	w.dispatcher = dispatcher
 
 	// NewTimer launches timer
 	// but it should be started after
@@ -200,6 +306,27 @@ func (w *WorkerNG) Token() Token {
 	return w.tokenManager.Token()
 }
 
+// Timeouts returns the timeouts the worker uses
+func (w *WorkerNG) Timeouts() WorkerTimeouts {
+	return w.timeouts
+}
+
+// DrainResult reports how the sessions in flight have been drained
+// on termination. It's meaningful once the drain is over,
+// see WithDrainHook.
+func (w *WorkerNG) DrainResult() DrainResult {
+	w.drainMu.Lock()
+	defer w.drainMu.Unlock()
+	return w.drainResult
+}
+
+// FlushStats returns the counters of the flushes to cocaine-runtime.
+// FramesPerFlush shows how well the replies are coalesced,
+// see WithWriteBatching.
+func (w *WorkerNG) FlushStats() FlushStats {
+	return w.conn.FlushStats()
+}
+
 // Run makes the worker anounce itself to a cocaine-runtime
 // as being ready to hadnle incoming requests and hablde them
 // terminationHandler allows to attach handler which will be called
@@ -210,24 +337,17 @@ func (w *WorkerNG) Run(handler RequestHandler, terminationHandler TerminationHan
 	return w.loop()
 }
 
-// Stop makes the Worker stop handling requests
+// Stop makes the Worker stop handling requests.
+// Contexts of the handlers in flight are cancelled with ErrWorkerStopped
+// unless they have been cancelled for another reason.
+// It's safe to call Stop concurrently and more than once.
 func (w *WorkerNG) Stop() {
-	if w.isStopped() {
-		return
-	}
-
-	w.tokenManager.Stop()
-	close(w.stopped)
-	w.conn.Close()
-}
-
-func (w *WorkerNG) isStopped() bool {
-	select {
-	case <-w.stopped:
-		return true
-	default:
-	}
-	return false
+	w.stopOnce.Do(func() {
+		w.active.CancelAll(ErrWorkerStopped)
+		w.tokenManager.Stop()
+		close(w.stopped)
+		w.conn.Close()
+	})
 }
 
 func (w *WorkerNG) loop() error {
@@ -244,30 +364,51 @@ func (w *WorkerNG) loop() error {
 	}
 
 	for {
+		var (
+			input     = w.conn.Read()
+			closed    <-chan struct{}
+			parked    chan<- *Message
+			parkedMsg *Message
+			discarded <-chan struct{}
+		)
+
+		if w.parked != nil {
+			// the connection is watched for the loss only
+			input, closed = nil, w.conn.IsClosed()
+			parked, parkedMsg, discarded = w.parked.input, w.parked.msg, w.parked.discarded
+		}
+
 		select {
-		case msg, ok := <-w.conn.Read():
+		case msg, ok := <-input:
 			if !ok {
-				// either the connection is lost
-				// or the worker was stopped
-				select {
-				case <-w.stopped:
-					return nil
-				default:
-					return ErrConnectionLost
-				}
+				return w.onConnectionClosed()
 			}
 
 			// non-blocking
-			if err := w.dispatcher.onMessage(w, msg); err != nil {
+			if err := w.dispatcher.OnMessage(workerProtocolHandler{w}, msg); err != nil {
 				fmt.Printf("onMessage returns %v\n", err)
 			}
 
+		case <-closed:
+			return w.onConnectionClosed()
+
+		case parked <- parkedMsg:
+			w.unpark()
+
+		case <-discarded:
+			w.unpark()
+
 		case <-w.heartbeatTimer.C:
 			// Reset (start) disown & heartbeat timers
 			// Send a heartbeat message to cocaine-runtime
 			w.onHeartbeatTimeout() // non-blocking
 
 		case <-w.disownTimer.C:
+			if w.parked != nil {
+				// the reply may wait behind the parked message
+				w.disownDeferred = true
+				continue
+			}
 			w.onDisownTimeout() // non-blocking
 			return ErrDisowned
 
@@ -280,6 +421,18 @@ func (w *WorkerNG) loop() error {
 	}
 }
 
+// onConnectionClosed tells if the connection is lost
+// or the worker was stopped
+func (w *WorkerNG) onConnectionClosed() error {
+	select {
+	case <-w.stopped:
+		return nil
+	default:
+		w.active.CancelAll(ErrConnectionLost)
+		return ErrConnectionLost
+	}
+}
+
 // printAllStacks prints all stacks to stderr and writes to a file
 func (w *WorkerNG) printAllStacks() {
 	stackTrace := dumpStack()
@@ -295,19 +448,20 @@ func (w *WorkerNG) printAllStacks() {
 // A reply to heartbeat is not arrived during disownTimeout,
 // so it seems cocaine-runtime has died
 func (w *WorkerNG) onDisownTimeout() {
+	w.active.CancelAll(ErrDisowned)
 	w.Stop()
 }
 
 func (w *WorkerNG) onHeartbeatTimeout() {
 	// Wait for the reply until disown timeout comes
-	w.disownTimer.Reset(disownTimeout)
+	w.disownTimer.Reset(w.timeouts.Disown)
 	// Send next heartbeat over heartbeatTimeout
-	w.heartbeatTimer.Reset(heartbeatTimeout)
+	w.heartbeatTimer.Reset(w.timeouts.Heartbeat)
 
 	select {
-	case w.conn.Write() <- w.dispatcher.newHeartbeat():
+	case w.conn.Write() <- w.dispatcher.NewHeartbeat():
 	case <-w.conn.IsClosed():
-	case <-time.After(disownTimeout):
+	case <-time.After(w.timeouts.Disown):
 	}
 }
 
@@ -316,9 +470,9 @@ func (w *WorkerNG) onHeartbeatTimeout() {
 // to notify runtime that we have started
 func (w *WorkerNG) sendHandshake() error {
 	select {
-	case w.conn.Write() <- w.dispatcher.newHandshake(w.id):
+	case w.conn.Write() <- w.dispatcher.NewHandshake(w.id):
 	case <-w.conn.IsClosed():
-	case <-time.After(disownTimeout):
+	case <-time.After(w.timeouts.Disown):
 		return fmt.Errorf("unable to send a handshake for a long time")
 	}
 	return nil
@@ -326,6 +480,19 @@ func (w *WorkerNG) sendHandshake() error {
 
 // Message handlers
 
+// workerProtocolHandler exposes the message handlers to a ProtocolDispatcher
+// without exporting them from WorkerNG
+type workerProtocolHandler struct {
+	w *WorkerNG
+}
+
+func (h workerProtocolHandler) OnChoke(msg *Message)        { h.w.onChoke(msg) }
+func (h workerProtocolHandler) OnChunk(msg *Message)        { h.w.onChunk(msg) }
+func (h workerProtocolHandler) OnError(msg *Message)        { h.w.onError(msg) }
+func (h workerProtocolHandler) OnHeartbeat(msg *Message)    { h.w.onHeartbeat(msg) }
+func (h workerProtocolHandler) OnInvoke(msg *Message) error { return h.w.onInvoke(msg) }
+func (h workerProtocolHandler) OnTerminate(msg *Message)    { h.w.onTerminate(msg) }
+
 func (w *WorkerNG) onChoke(msg *Message) {
 	if reqStream, ok := w.sessions[msg.Session]; ok {
 		reqStream.Close()
@@ -334,14 +501,45 @@ func (w *WorkerNG) onChoke(msg *Message) {
 }
 
 func (w *WorkerNG) onChunk(msg *Message) {
-	if reqStream, ok := w.sessions[msg.Session]; ok {
-		reqStream.push(msg)
-	}
+	w.push(msg, nil)
 }
 
 func (w *WorkerNG) onError(msg *Message) {
+	w.push(msg, func() {
+		// a client has broken the session
+		w.active.Cancel(msg.Session, w.dispatcher.DecodeError(msg))
+	})
+}
+
+// push passes the message to the request of its session
+// or parks it if the request is at its high watermark
+func (w *WorkerNG) push(msg *Message, then func()) {
 	if reqStream, ok := w.sessions[msg.Session]; ok {
-		reqStream.push(msg)
+		input, discarded := reqStream.queue()
+		select {
+		case input <- msg:
+		case <-discarded:
+		default:
+			w.parked = &parkedMessage{msg: msg, input: input, discarded: discarded, then: then}
+			return
+		}
+	}
+
+	if then != nil {
+		then()
+	}
+}
+
+func (w *WorkerNG) unpark() {
+	parked := w.parked
+	w.parked = nil
+	if w.disownDeferred {
+		// give the reply the time to be read
+		w.disownDeferred = false
+		w.disownTimer.Reset(w.timeouts.Disown)
+	}
+	if parked.then != nil {
+		parked.then()
 	}
 }
 
@@ -352,22 +550,68 @@ func (w *WorkerNG) onInvoke(msg *Message) error {
 		return fmt.Errorf("unable to get an event name from %s", msg.String())
 	}
 
+	if w.terminating {
+		w.conn.Send(w.dispatcher.NewError(
+			msg.Session,
+			cworkererrorcategory,
+			ErrorWorkerTerminating,
+			fmt.Sprintf("Event: '%s', the worker is terminating", event),
+		))
+		return nil
+	}
+
 	var (
 		currentSession = msg.Session
+		arrived        = time.Now()
 		ctx            context.Context
+		cancel         context.CancelCauseFunc
 	)
 
-	ctx = context.Background()
+	// The context is cancelled when the session is closed, broken by the client
+	// or the worker stops. context.Cause reports the reason.
+	ctx, cancel = context.WithCancelCause(context.Background())
+	w.active.Attach(currentSession, &handlerSession{cancel: cancel})
 
-	if traceInfo, err := msg.Headers.getTraceData(); err == nil {
+	if traceInfo, err := msg.headers().getTraceData(); err == nil {
 		ctx = AttachTraceInfo(ctx, traceInfo)
 	}
 
-	responseStream := newResponse(w.dispatcher, currentSession, w.conn)
-	requestStream := newRequest(w.dispatcher)
+	ctx = withSessionInfo(ctx, &SessionInfo{
+		ID:       currentSession,
+		Event:    event,
+		Arrived:  arrived,
+		Headers:  msg.HeaderFields(),
+		WorkerID: w.id,
+		AppName:  w.appName,
+	})
+
+	responseStream := newResponse(ctx, w.dispatcher, currentSession, w.conn, func() {
+		w.active.Detach(currentSession, ErrSessionClosed)
+	})
+	requestStream := newRequest(w.dispatcher, w.watermarks)
 	w.sessions[currentSession] = requestStream
 
 	go func() {
+		// the chunks are dropped once the handler is over,
+		// so they don't hold the worker at the watermarks
+		defer requestStream.discard()
+
+		release, err := w.limiter.acquire(ctx, event)
+		if err != nil {
+			if err != ErrTooManySessions && err != ErrQueueTimeout {
+				// the session has been cancelled while waiting,
+				// nobody is going to close its response
+				w.active.Detach(currentSession, context.Cause(ctx))
+				return
+			}
+			responseStream.ErrorMsg(
+				ErrorWorkerOverloaded,
+				fmt.Sprintf("Event: '%s', %v", event, err),
+			)
+			return
+		}
+		defer release()
+
 		// this trap catches a panic from a handler
 		// and checks if the response is closed.
 		defer trapRecoverAndClose(ctx, event, responseStream, w.debug)
@@ -388,8 +632,31 @@ func (w *WorkerNG) onHeartbeat(msg *Message) {
 }
 
 func (w *WorkerNG) onTerminate(msg *Message) {
+	if w.drainTimeout == 0 {
+		// without the drain the termination runs on the loop,
+		// so no invokes are handled after the termination message
+		w.terminate(msg)
+		return
+	}
+
+	if w.terminating {
+		// the termination is in progress
+		return
+	}
+	w.terminating = true
+
+	// the loop keeps delivering chunks to the sessions in flight
+	// and sending heartbeats while the worker is terminating
+	go w.terminate(msg)
+}
+
+func (w *WorkerNG) terminate(msg *Message) {
+	if w.drainTimeout > 0 {
+		w.drain()
+	}
+
 	if w.terminationHandler != nil {
-		ctx, cancelTimeout := context.WithTimeout(context.Background(), terminationTimeout)
+		ctx, cancelTimeout := context.WithTimeout(context.Background(), w.timeouts.Termination)
 		onDone := make(chan struct{})
 		go func() {
 			w.terminationHandler(ctx)
@@ -410,7 +677,33 @@ func (w *WorkerNG) onTerminate(msg *Message) {
 	case w.conn.Write() <- msg:
 		// reply with the same termination message
 	case <-w.conn.IsClosed():
-	case <-time.After(disownTimeout):
+	case <-time.After(w.timeouts.Disown):
 	}
 	w.Stop()
 }
+
+// drain waits for the sessions in flight to close their responses
+func (w *WorkerNG) drain() {
+	inflight := w.active.Len()
+
+	select {
+	case <-w.active.Drained():
+	case <-w.stopped:
+	case <-time.After(w.drainTimeout):
+		w.active.CancelAll(ErrDrainTimeout)
+	}
+
+	abandoned := w.active.Len()
+	result := DrainResult{
+		Finished:  inflight - abandoned,
+		Abandoned: abandoned,
+	}
+
+	w.drainMu.Lock()
+	w.drainResult = result
+	w.drainMu.Unlock()
+
+	if w.drainHook != nil {
+		w.drainHook(result)
+	}
+}
@@ -0,0 +1,42 @@
+package cocaine12
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testProtocolV0 = testProtocol{
+	version: v0,
+	format:  v0WireFormat{},
+
+	utilitySession: v0UtilitySession,
+	handshakeType:  v0Handshake,
+	heartbeatType:  v0Heartbeat,
+	terminateType:  v0Terminate,
+	writeType:      v0Write,
+	errorType:      v0Error,
+	closeType:      v0Close,
+
+	newInvoke:    newInvokeV0,
+	newChunk:     newChunkV0,
+	newChoke:     newChokeV0,
+	newHeartbeat: newHeartbeatV0,
+}
+
+func TestWorkerV0(t *testing.T) {
+	testWorker(t, testProtocolV0)
+}
+
+func TestWorkerV0Termination(t *testing.T) {
+	testWorkerTermination(t, testProtocolV0)
+}
+
+func TestRequestErrorV0(t *testing.T) {
+	req := newRequest(newV0Protocol(), DefaultWatermarks())
+	req.push(newErrorV0(2, 200, "error"))
+
+	_, err := req.Read(context.Background())
+	assert.Equal(t, &ErrRequest{Message: "error", Code: 200}, err)
+}
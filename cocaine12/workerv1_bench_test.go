@@ -1,9 +1,13 @@
 package cocaine12
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"testing"
 	"time"
+
+	"github.com/ugorji/go/codec"
 )
 
 func genBullets(sock *asyncRWSocket, bullets uint64) {
@@ -59,6 +63,41 @@ func doBenchmarkWorkerEcho(b *testing.B, bullets uint64) {
 	}
 }
 
+// doBenchmarkStreamWrite measures a handler streaming small chunks
+// through a socket and reports how many frames a flush carries
+func doBenchmarkStreamWrite(b *testing.B, batching WriteBatching) {
+	conn := &writesConn{closed: make(chan struct{})}
+	sock, _ := newAsyncRWWithOptions(conn, defaultWireFormat{}, socketOptions{batching: batching})
+	defer sock.Close()
+
+	chunk := newChunkV1(1, []byte("Dummy"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sock.Write() <- chunk
+	}
+	for sock.FlushStats().Frames < uint64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+
+	stats := sock.FlushStats()
+	b.ReportMetric(stats.FramesPerFlush(), "frames/flush")
+}
+
+func BenchmarkStreamWriteNoDelay(b *testing.B) {
+	doBenchmarkStreamWrite(b, DefaultWriteBatching())
+}
+
+func BenchmarkStreamWriteOneFramePerFlush(b *testing.B) {
+	doBenchmarkStreamWrite(b, WriteBatching{MaxBytes: 1})
+}
+
+func BenchmarkStreamWriteDelay100us(b *testing.B) {
+	doBenchmarkStreamWrite(b, WriteBatching{MaxDelay: 100 * time.Microsecond})
+}
+
 func BenchmarkWorkerEcho10(b *testing.B) {
 	doBenchmarkWorkerEcho(b, 10)
 }
@@ -70,3 +109,72 @@ func BenchmarkWorkerEcho100(b *testing.B) {
 func BenchmarkWorkerEcho1000(b *testing.B) {
 	doBenchmarkWorkerEcho(b, 1000)
 }
+
+type benchPayload struct {
+	Name  string
+	Count int
+	Tags  []string
+	Body  []byte
+}
+
+func benchFrame(b *testing.B) []byte {
+	var frame []byte
+	msg := &Message{
+		CommonMessageInfo: CommonMessageInfo{Session: 10, MsgType: 0},
+		Payload: []interface{}{
+			"apples", 42, []string{"red", "green", "yellow"}, bytes.Repeat([]byte("x"), 512),
+		},
+		Headers: newCocaineHeaders([]HeaderField{{"X-Request-Id", []byte("abc")}}),
+	}
+	if err := codec.NewEncoderBytes(&frame, hAsocket).Encode(msg); err != nil {
+		b.Fatal(err)
+	}
+	return frame
+}
+
+// BenchmarkDecodeExtractEager decodes a frame into []interface{}
+// and converts it into the target as it has been done before
+func BenchmarkDecodeExtractEager(b *testing.B) {
+	frame := benchFrame(b)
+	r := bytes.NewReader(frame)
+	decoder := codec.NewDecoder(bufio.NewReader(r), hAsocket)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame)
+		var msg *Message
+		if err := decoder.Decode(&msg); err != nil {
+			b.Fatal(err)
+		}
+
+		var out benchPayload
+		if err := convertPayload(msg.Payload, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeExtractRaw keeps the raw payload of a frame
+// and decodes it straight into the target
+func BenchmarkDecodeExtractRaw(b *testing.B) {
+	frame := benchFrame(b)
+	r := bytes.NewReader(frame)
+	reader := bufio.NewReader(r)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(frame)
+		reader.Reset(r)
+		msg, err := defaultWireFormat{}.decode(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var out benchPayload
+		if err := newServiceRes(msg).Extract(&out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
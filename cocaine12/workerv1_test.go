@@ -6,6 +6,7 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -43,7 +44,53 @@ func checkTypeAndSession(t *testing.T, msg *Message, eSession uint64, eType uint
 	}
 }
 
+// testProtocol describes a worker protocol
+// to run the same test suite against each version
+type testProtocol struct {
+	version int
+	format  wireFormat
+
+	utilitySession uint64
+	handshakeType  uint64
+	heartbeatType  uint64
+	terminateType  uint64
+	writeType      uint64
+	errorType      uint64
+	closeType      uint64
+
+	newInvoke    func(session uint64, event string) *Message
+	newChunk     func(session uint64, data []byte) *Message
+	newChoke     func(session uint64) *Message
+	newHeartbeat func() *Message
+}
+
+var testProtocolV1 = testProtocol{
+	version: v1,
+	format:  defaultWireFormat{},
+
+	utilitySession: v1UtilitySession,
+	handshakeType:  v1Handshake,
+	heartbeatType:  v1Heartbeat,
+	terminateType:  v1Terminate,
+	writeType:      v1Write,
+	errorType:      v1Error,
+	closeType:      v1Close,
+
+	newInvoke:    newInvokeV1,
+	newChunk:     newChunkV1,
+	newChoke:     newChokeV1,
+	newHeartbeat: newHeartbeatV1,
+}
+
 func TestWorkerV1(t *testing.T) {
+	testWorker(t, testProtocolV1)
+}
+
+func TestWorkerV1Termination(t *testing.T) {
+	testWorkerTermination(t, testProtocolV1)
+}
+
+func testWorker(t *testing.T, proto testProtocol) {
 	const (
 		testID      = "uuid"
 		testSession = 10
@@ -54,9 +101,9 @@ func TestWorkerV1(t *testing.T) {
 	)
 
 	in, out := testConn()
-	sock, _ := newAsyncRW(out)
-	sock2, _ := newAsyncRW(in)
-	w, err := newWorker(sock, testID, 1, true)
+	sock, _ := newAsyncRWWithFormat(out, proto.format)
+	sock2, _ := newAsyncRWWithFormat(in, proto.format)
+	w, err := newWorker(sock, testID, proto.version, true)
 	if err != nil {
 		t.Fatal("unable to create worker", err)
 	}
@@ -91,22 +138,22 @@ func TestWorkerV1(t *testing.T) {
 		close(onStop)
 	}()
 
-	corrupted := newInvokeV1(testSession-1, "AAA")
+	corrupted := proto.newInvoke(testSession-1, "AAA")
 	corrupted.Payload = []interface{}{nil}
 	sock2.Write() <- corrupted
 
-	sock2.Write() <- newInvokeV1(testSession, "test")
-	sock2.Write() <- newChunkV1(testSession, []byte("Dummy"))
-	sock2.Write() <- newChokeV1(testSession)
+	sock2.Write() <- proto.newInvoke(testSession, "test")
+	sock2.Write() <- proto.newChunk(testSession, []byte("Dummy"))
+	sock2.Write() <- proto.newChoke(testSession)
 
 	// handshake
 	eHandshake, ok := <-sock2.Read()
 	if eHandshake == nil {
 		t.Fatalf("Corrupted message %s %v", eHandshake, ok)
 	}
-	checkTypeAndSession(t, eHandshake, v1UtilitySession, v1Handshake)
+	checkTypeAndSession(t, eHandshake, proto.utilitySession, proto.handshakeType)
 
-	switch uuid := eHandshake.Payload[0].(type) {
+	switch uuid := eHandshake.payload()[0].(type) {
 	case string:
 		if uuid != testID {
 			t.Fatal("bad uuid")
@@ -120,70 +167,70 @@ func TestWorkerV1(t *testing.T) {
 	}
 
 	eHeartbeat := <-sock2.Read()
-	checkTypeAndSession(t, eHeartbeat, v1UtilitySession, v1Heartbeat)
+	checkTypeAndSession(t, eHeartbeat, proto.utilitySession, proto.heartbeatType)
 
 	// test event
 	eChunk := <-sock2.Read()
-	checkTypeAndSession(t, eChunk, testSession, v1Write)
-	assert.Equal(t, []byte("Dummy"), eChunk.Payload[0])
+	checkTypeAndSession(t, eChunk, testSession, proto.writeType)
+	assert.Equal(t, []byte("Dummy"), eChunk.payload()[0])
 	eChoke := <-sock2.Read()
-	checkTypeAndSession(t, eChoke, testSession, v1Close)
+	checkTypeAndSession(t, eChoke, testSession, proto.closeType)
 
 	// http event
 	// status code & headers
 	t.Log("HTTP test:")
-	sock2.Write() <- newInvokeV1(testSession+1, "http")
-	sock2.Write() <- newChunkV1(testSession+1, packTestReq(req))
-	sock2.Write() <- newChokeV1(testSession + 1)
+	sock2.Write() <- proto.newInvoke(testSession+1, "http")
+	sock2.Write() <- proto.newChunk(testSession+1, packTestReq(req))
+	sock2.Write() <- proto.newChoke(testSession + 1)
 
 	eChunk = <-sock2.Read()
-	checkTypeAndSession(t, eChunk, testSession+1, v1Write)
+	checkTypeAndSession(t, eChunk, testSession+1, proto.writeType)
 	var firstChunk struct {
 		Status  int
 		Headers [][2]string
 	}
-	assert.NoError(t, testUnpackHTTPChunk(eChunk.Payload, &firstChunk))
+	assert.NoError(t, testUnpackHTTPChunk(eChunk.payload(), &firstChunk))
 	assert.Equal(t, http.StatusProxyAuthRequired, firstChunk.Status, "http: invalid status code")
 	assert.Equal(t, [][2]string{[2]string{"X-Test", "Test"}}, firstChunk.Headers, "http: headers")
 	// body
 	eChunk = <-sock2.Read()
-	checkTypeAndSession(t, eChunk, testSession+1, v1Write)
-	assert.Equal(t, []byte("OK"), eChunk.Payload[0].([]byte), "http: invalid body %s", eChunk.Payload[0])
+	checkTypeAndSession(t, eChunk, testSession+1, proto.writeType)
+	assert.Equal(t, []byte("OK"), eChunk.payload()[0].([]byte), "http: invalid body %s", eChunk.payload()[0])
 	eChoke = <-sock2.Read()
-	checkTypeAndSession(t, eChoke, testSession+1, v1Close)
+	checkTypeAndSession(t, eChoke, testSession+1, proto.closeType)
 
 	// error event
 	t.Log("error event")
-	sock2.Write() <- newInvokeV1(testSession+2, "error")
-	sock2.Write() <- newChunkV1(testSession+2, []byte("Dummy"))
-	sock2.Write() <- newChokeV1(testSession + 2)
+	sock2.Write() <- proto.newInvoke(testSession+2, "error")
+	sock2.Write() <- proto.newChunk(testSession+2, []byte("Dummy"))
+	sock2.Write() <- proto.newChoke(testSession + 2)
 
 	eError := <-sock2.Read()
-	checkTypeAndSession(t, eError, testSession+2, v1Error)
+	checkTypeAndSession(t, eError, testSession+2, proto.errorType)
 
 	// badevent
 	t.Log("badevent event")
-	sock2.Write() <- newInvokeV1(testSession+3, "BadEvent")
-	sock2.Write() <- newChunkV1(testSession+3, []byte("Dummy"))
-	sock2.Write() <- newChokeV1(testSession + 3)
+	sock2.Write() <- proto.newInvoke(testSession+3, "BadEvent")
+	sock2.Write() <- proto.newChunk(testSession+3, []byte("Dummy"))
+	sock2.Write() <- proto.newChoke(testSession + 3)
 
 	eError = <-sock2.Read()
-	checkTypeAndSession(t, eError, testSession+3, v1Error)
+	checkTypeAndSession(t, eError, testSession+3, proto.errorType)
 
 	// panic
 	t.Log("panic event")
-	sock2.Write() <- newInvokeV1(testSession+4, "panic")
-	sock2.Write() <- newChunkV1(testSession+4, []byte("Dummy"))
-	sock2.Write() <- newChokeV1(testSession + 4)
+	sock2.Write() <- proto.newInvoke(testSession+4, "panic")
+	sock2.Write() <- proto.newChunk(testSession+4, []byte("Dummy"))
+	sock2.Write() <- proto.newChoke(testSession + 4)
 
 	eError = <-sock2.Read()
-	checkTypeAndSession(t, eError, testSession+4, v1Error)
+	checkTypeAndSession(t, eError, testSession+4, proto.errorType)
 
 	<-onStop
 	w.Stop()
 }
 
-func TestWorkerV1Termination(t *testing.T) {
+func testWorkerTermination(t *testing.T, proto testProtocol) {
 	const (
 		testID = "uuid"
 	)
@@ -191,9 +238,9 @@ func TestWorkerV1Termination(t *testing.T) {
 	var onStop = make(chan struct{})
 
 	in, out := testConn()
-	sock, _ := newAsyncRW(out)
-	sock2, _ := newAsyncRW(in)
-	w, err := newWorker(sock, testID, 1, true)
+	sock, _ := newAsyncRWWithFormat(out, proto.format)
+	sock2, _ := newAsyncRWWithFormat(in, proto.format)
+	w, err := newWorker(sock, testID, proto.version, true)
 	if err != nil {
 		t.Fatal("unable to create worker", err)
 	}
@@ -207,23 +254,23 @@ func TestWorkerV1Termination(t *testing.T) {
 	if eHandshake == nil {
 		t.Fatalf("Corrupted message %s %v", eHandshake, ok)
 	}
-	checkTypeAndSession(t, eHandshake, v1UtilitySession, v1Handshake)
+	checkTypeAndSession(t, eHandshake, proto.utilitySession, proto.handshakeType)
 	eHeartbeat := <-sock2.Read()
-	checkTypeAndSession(t, eHeartbeat, v1UtilitySession, v1Heartbeat)
+	checkTypeAndSession(t, eHeartbeat, proto.utilitySession, proto.heartbeatType)
 
-	sock2.Write() <- newHeartbeatV1()
+	sock2.Write() <- proto.newHeartbeat()
 
 	terminate := &Message{
 		CommonMessageInfo: CommonMessageInfo{
-			Session: v1UtilitySession,
-			MsgType: v1Terminate,
+			Session: proto.utilitySession,
+			MsgType: proto.terminateType,
 		},
 		Payload: []interface{}{100, "TestTermination"},
 	}
 
 	corrupted := &Message{
 		CommonMessageInfo: CommonMessageInfo{
-			Session: v1UtilitySession,
+			Session: proto.utilitySession,
 			MsgType: 9999,
 		},
 		Payload: []interface{}{100, "TestTermination"},
@@ -238,7 +285,7 @@ func TestWorkerV1Termination(t *testing.T) {
 	case <-time.After(heartbeatTimeout + time.Second):
 		t.Fatalf("unexpected timeout")
 	case eHeartbeat := <-sock2.Read():
-		checkTypeAndSession(t, eHeartbeat, v1UtilitySession, v1Heartbeat)
+		checkTypeAndSession(t, eHeartbeat, proto.utilitySession, proto.heartbeatType)
 	}
 
 	sock2.Write() <- terminate
@@ -333,3 +380,388 @@ func TestWorkerLoad(t *testing.T) {
 		t.Fatalf("unexpected exit")
 	}
 }
+
+func TestWorkerTimeoutOptions(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	_, err := newWorker(sock, "uuid", 1, true, WithDisownTimeout(time.Minute))
+	assert.Error(t, err, "disown timeout exceeds heartbeat timeout")
+	_, err = newWorker(sock, "uuid", 1, true, WithTerminationTimeout(-time.Second))
+	assert.Error(t, err, "negative termination timeout")
+
+	w, err := newWorker(sock, "uuid", 1, true,
+		WithHeartbeatTimeout(time.Minute),
+		WithTimeouts(WorkerTimeouts{Disown: 20 * time.Second}))
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	assert.Equal(t, WorkerTimeouts{
+		Heartbeat:      time.Minute,
+		Disown:         20 * time.Second,
+		CoreConnection: coreConnectionTimeout,
+		Termination:    terminationTimeout,
+	}, w.Timeouts())
+}
+
+func TestWorkerHandlerContextCancellation(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+
+	var (
+		causes  = make(chan error, 2)
+		started = make(chan struct{}, 2)
+	)
+	w.On("wait", func(ctx context.Context, req Request, res Response) {
+		started <- struct{}{}
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+	})
+
+	onStop := make(chan struct{})
+	go func() {
+		w.Run(nil)
+		close(onStop)
+	}()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	// the client breaks the session
+	sock2.Write() <- newInvokeV1(2, "wait")
+	sock2.Write() <- newErrorV1(2, 100, 200, "client error")
+
+	select {
+	case cause := <-causes:
+		assert.Equal(t, &ErrRequest{"client error", 100, 200}, cause)
+	case <-time.After(time.Second):
+		t.Fatal("the handler context has not been cancelled by an error")
+	}
+
+	// the worker stops with a handler in flight
+	sock2.Write() <- newInvokeV1(3, "wait")
+	<-started
+	<-started
+	w.Stop()
+
+	select {
+	case cause := <-causes:
+		assert.Equal(t, ErrWorkerStopped, cause)
+	case <-time.After(time.Second):
+		t.Fatal("the handler context has not been cancelled by Stop")
+	}
+	<-onStop
+}
+
+func TestWorkerSessionInfo(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+
+	infos := make(chan *SessionInfo, 1)
+	w.On("test", func(ctx context.Context, req Request, res Response) {
+		info, ok := SessionFromContext(ctx)
+		assert.True(t, ok)
+		infos <- info
+		res.Close()
+	})
+
+	go w.Run(nil)
+	defer w.Stop()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	invoke := newInvokeV1(2, "test")
+	invoke.Headers = CocaineHeaders{
+		[]interface{}{false, "authorization", "OAuth token"},
+		[]interface{}{false, 80, []byte{1, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	before := time.Now()
+	sock2.Write() <- invoke
+
+	select {
+	case info := <-infos:
+		assert.Equal(t, uint64(2), info.ID)
+		assert.Equal(t, "test", info.Event)
+		assert.Equal(t, "uuid", info.WorkerID)
+		assert.False(t, info.Arrived.Before(before))
+
+		value, ok := info.Header("authorization")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("OAuth token"), value)
+
+		value, ok = info.Header("trace_id")
+		assert.True(t, ok)
+		assert.Equal(t, []byte{1, 0, 0, 0, 0, 0, 0, 0}, value)
+
+		_, ok = info.Header("missing")
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("the handler has not been called")
+	}
+
+	_, ok := SessionFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestWorkerResponseHeaders(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+
+	w.On("test", func(ctx context.Context, req Request, res Response) {
+		res.ZeroCopyWriteWithHeaders([]byte("chunk"), HeaderField{"chunk", []byte("1")})
+		res.CloseWithHeaders(HeaderField{"choke", []byte("2")})
+	})
+	w.On("fail", func(ctx context.Context, req Request, res Response) {
+		res.ErrorMsgWithHeaders(100, "error", HeaderField{"error", []byte("3")})
+	})
+
+	go w.Run(nil)
+	defer w.Stop()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	sock2.Write() <- newInvokeV1(2, "test")
+	chunk := <-sock2.Read()
+	assert.Equal(t, uint64(v1Write), chunk.MsgType)
+	assert.Equal(t, []HeaderField{{"chunk", []byte("1")}}, chunk.headers().Fields())
+
+	choke := <-sock2.Read()
+	assert.Equal(t, uint64(v1Close), choke.MsgType)
+	assert.Equal(t, []HeaderField{{"choke", []byte("2")}}, choke.headers().Fields())
+
+	sock2.Write() <- newInvokeV1(3, "fail")
+	eError := <-sock2.Read()
+	assert.Equal(t, uint64(v1Error), eError.MsgType)
+	assert.Equal(t, []HeaderField{{"error", []byte("3")}}, eError.headers().Fields())
+}
+
+func TestWorkerV1Drain(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	drained := make(chan DrainResult, 1)
+	w, err := newWorker(sock, "uuid", 1, true,
+		WithDrainTimeout(time.Second),
+		WithDrainHook(func(result DrainResult) { drained <- result }))
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+
+	w.On("echo", func(ctx context.Context, req Request, res Response) {
+		data, _ := req.Read(ctx)
+		res.Write(data)
+		res.Close()
+	})
+	w.On("hang", func(ctx context.Context, req Request, res Response) {
+		<-ctx.Done()
+		assert.Equal(t, ErrDrainTimeout, context.Cause(ctx))
+	})
+
+	onStop := make(chan struct{})
+	go func() {
+		w.Run(nil)
+		close(onStop)
+	}()
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	sock2.Write() <- newInvokeV1(2, "echo")
+	sock2.Write() <- newInvokeV1(3, "hang")
+	sock2.Write() <- &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: v1UtilitySession,
+			MsgType: v1Terminate,
+		},
+		Payload: []interface{}{100, "TestDrain"},
+	}
+	// new invokes are rejected
+	sock2.Write() <- newInvokeV1(4, "echo")
+	eError := <-sock2.Read()
+	checkTypeAndSession(t, eError, 4, v1Error)
+
+	// the session in flight still gets its chunks
+	sock2.Write() <- newChunkV1(2, []byte("Dummy"))
+	eChunk := <-sock2.Read()
+	checkTypeAndSession(t, eChunk, 2, v1Write)
+	assert.Equal(t, []byte("Dummy"), eChunk.payload()[0])
+	eChoke := <-sock2.Read()
+	checkTypeAndSession(t, eChoke, 2, v1Close)
+
+	// "hang" is abandoned after the drain timeout
+	select {
+	case <-onStop:
+	case <-time.After(disownTimeout):
+		t.Fatalf("unexpected exit")
+	}
+	assert.Equal(t, DrainResult{Finished: 1, Abandoned: 1}, <-drained)
+	assert.Equal(t, DrainResult{Finished: 1, Abandoned: 1}, w.impl.DrainResult())
+}
+
+func TestWorkerConcurrentStop(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true)
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+
+	onStop := make(chan struct{})
+	go func() {
+		w.Run(nil)
+		close(onStop)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Stop()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-onStop:
+	case <-time.After(time.Second):
+		t.Fatal("the worker isn't stopped")
+	}
+}
+
+func TestWorkerV1SessionLimits(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true,
+		WithMaxSessions(2),
+		WithMaxEventSessions("block", 1),
+		WithSessionQueue(1, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	unblock := make(chan struct{})
+	w.On("block", func(ctx context.Context, req Request, res Response) {
+		<-unblock
+	})
+
+	go w.Run(nil)
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	sock2.Write() <- newInvokeV1(2, "block")
+	// the invokes may arrive in one batch, so let the first take the slot
+	time.Sleep(10 * time.Millisecond)
+	// waits in the queue for the event limit
+	sock2.Write() <- newInvokeV1(3, "block")
+	time.Sleep(10 * time.Millisecond)
+	// the queue is full
+	sock2.Write() <- newInvokeV1(4, "block")
+
+	eError := <-sock2.Read()
+	checkTypeAndSession(t, eError, 4, v1Error)
+	assert.Equal(t, ErrorWorkerOverloaded, decodeErrorMessage(eError).(*ErrRequest).Code)
+
+	// the queue timeout
+	eError = <-sock2.Read()
+	checkTypeAndSession(t, eError, 3, v1Error)
+	assert.Equal(t, ErrorWorkerOverloaded, decodeErrorMessage(eError).(*ErrRequest).Code)
+
+	close(unblock)
+	eChoke := <-sock2.Read()
+	checkTypeAndSession(t, eChoke, 2, v1Close)
+}
+
+func TestWorkerV1QueuedSessionCancel(t *testing.T) {
+	in, out := testConn()
+	sock, _ := newAsyncRW(out)
+	sock2, _ := newAsyncRW(in)
+	defer sock2.Close()
+
+	w, err := newWorker(sock, "uuid", 1, true,
+		WithMaxSessions(1),
+		WithSessionQueue(1, 0))
+	if err != nil {
+		t.Fatal("unable to create worker", err)
+	}
+	defer w.Stop()
+
+	unblock := make(chan struct{})
+	w.On("block", func(ctx context.Context, req Request, res Response) {
+		<-unblock
+		res.Close()
+	})
+
+	go w.Run(nil)
+
+	// handshake & heartbeat
+	<-sock2.Read()
+	<-sock2.Read()
+
+	sock2.Write() <- newInvokeV1(2, "block")
+	time.Sleep(10 * time.Millisecond)
+	sock2.Write() <- newInvokeV1(3, "block")
+	time.Sleep(10 * time.Millisecond)
+	// the client breaks the queued session
+	sock2.Write() <- newErrorV1(3, 1, 1, "cancel")
+	time.Sleep(10 * time.Millisecond)
+
+	// it's detached without a reply
+	assert.Equal(t, 1, w.impl.active.Len())
+
+	// the following sessions are still queued and served
+	sock2.Write() <- newInvokeV1(4, "block")
+	time.Sleep(10 * time.Millisecond)
+
+	// it's not answered as overloaded
+	close(unblock)
+	eChoke := <-sock2.Read()
+	checkTypeAndSession(t, eChoke, 2, v1Close)
+	eChoke = <-sock2.Read()
+	checkTypeAndSession(t, eChoke, 4, v1Close)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, w.impl.active.Len())
+}
@@ -0,0 +1,194 @@
+package cocaine12
+
+import (
+	"bufio"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	v0Handshake = 0
+	v0Heartbeat = 1
+	v0Terminate = 2
+	v0Invoke    = 3
+	v0Write     = 4
+	v0Error     = 5
+	v0Close     = 6
+
+	v0UtilitySession = 0
+)
+
+// v0WireFormat lays a message out as [type, session, payload].
+// The protocol v0 has no headers.
+type v0WireFormat struct{}
+
+type v0Frame struct {
+	MsgType uint64
+	Session uint64
+	Payload []interface{}
+}
+
+func (v0WireFormat) encode(enc *codec.Encoder, msg *Message) error {
+	return enc.Encode(&v0Frame{
+		MsgType: msg.MsgType,
+		Session: msg.Session,
+		Payload: msg.Payload,
+	})
+}
+
+func (v0WireFormat) decode(r *bufio.Reader) (*Message, error) {
+	frame, err := readRawFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s := rawSlice{data: frame}
+	n, err := s.arrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 3 {
+		return nil, fmt.Errorf("malformed v0 frame of %d items", n)
+	}
+
+	var message Message
+	if message.MsgType, err = s.uint(); err != nil {
+		return nil, err
+	}
+	if message.Session, err = s.uint(); err != nil {
+		return nil, err
+	}
+	if message.rawPayload, err = s.next(); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+type v0Protocol struct{}
+
+func newV0Protocol() ProtocolDispatcher {
+	return &v0Protocol{}
+}
+
+func (v *v0Protocol) OnMessage(p ProtocolHandler, msg *Message) error {
+	switch msg.MsgType {
+	case v0Heartbeat:
+		p.OnHeartbeat(msg)
+	case v0Terminate:
+		p.OnTerminate(msg)
+	case v0Invoke:
+		return p.OnInvoke(msg)
+	case v0Write:
+		p.OnChunk(msg)
+	case v0Error:
+		p.OnError(msg)
+	case v0Close:
+		p.OnChoke(msg)
+	default:
+		return fmt.Errorf("an invalid message type: %d, message %v", msg.MsgType, msg)
+	}
+	return nil
+}
+
+func (v *v0Protocol) IsChunk(msg *Message) bool {
+	return msg.MsgType == v0Write
+}
+
+// DecodeError unpacks [code, message] as the protocol v0
+// has no error categories
+func (v *v0Protocol) DecodeError(msg *Message) error {
+	var perr struct {
+		Code    int
+		Message string
+	}
+
+	if err := msg.DecodePayload(&perr); err != nil {
+		return ErrMalformedErrorMessage
+	}
+
+	return &ErrRequest{
+		Message: perr.Message,
+		Code:    perr.Code,
+	}
+}
+
+func (v *v0Protocol) NewHandshake(id string) *Message {
+	return newHandshakeV0(id)
+}
+
+func (v *v0Protocol) NewHeartbeat() *Message {
+	return newHeartbeatV0()
+}
+
+func (v *v0Protocol) NewChoke(session uint64) *Message {
+	return newChokeV0(session)
+}
+
+func (v *v0Protocol) NewChunk(session uint64, data []byte) *Message {
+	return newChunkV0(session, data)
+}
+
+func (v *v0Protocol) NewError(session uint64, category, code int, message string) *Message {
+	return newErrorV0(session, code, message)
+}
+
+func newHandshakeV0(id string) *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: v0UtilitySession,
+			MsgType: v0Handshake,
+		},
+		Payload: []interface{}{id},
+	}
+}
+
+func newHeartbeatV0() *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: v0UtilitySession,
+			MsgType: v0Heartbeat,
+		},
+		Payload: []interface{}{},
+	}
+}
+
+func newInvokeV0(session uint64, event string) *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: session,
+			MsgType: v0Invoke,
+		},
+		Payload: []interface{}{event},
+	}
+}
+
+func newChunkV0(session uint64, data []byte) *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: session,
+			MsgType: v0Write,
+		},
+		Payload: []interface{}{data},
+	}
+}
+
+func newErrorV0(session uint64, code int, message string) *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: session,
+			MsgType: v0Error,
+		},
+		Payload: []interface{}{code, message},
+	}
+}
+
+func newChokeV0(session uint64) *Message {
+	return &Message{
+		CommonMessageInfo: CommonMessageInfo{
+			Session: session,
+			MsgType: v0Close,
+		},
+		Payload: []interface{}{},
+	}
+}
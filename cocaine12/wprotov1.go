@@ -20,13 +20,13 @@ type v1Protocol struct {
 	maxSession uint64
 }
 
-func newV1Protocol() protocolDispather {
+func newV1Protocol() ProtocolDispatcher {
 	return &v1Protocol{
 		maxSession: 1,
 	}
 }
 
-func (v *v1Protocol) onMessage(p protocolHandler, msg *Message) error {
+func (v *v1Protocol) OnMessage(p ProtocolHandler, msg *Message) error {
 	if msg.Session == v1UtilitySession {
 		return v.dispatchUtilityMessage(p, msg)
 	}
@@ -39,32 +39,36 @@ func (v *v1Protocol) onMessage(p protocolHandler, msg *Message) error {
 		}
 
 		v.maxSession = msg.Session
-		return p.onInvoke(msg)
+		return p.OnInvoke(msg)
 	}
 
 	switch msg.MsgType {
 	case v1Write:
-		p.onChunk(msg)
+		p.OnChunk(msg)
 	case v1Close:
-		p.onChoke(msg)
+		p.OnChoke(msg)
 	case v1Error:
-		p.onError(msg)
+		p.OnError(msg)
 	default:
 		return fmt.Errorf("an invalid message type: %d, message %v", msg.MsgType, msg)
 	}
 	return nil
 }
 
-func (v *v1Protocol) isChunk(msg *Message) bool {
+func (v *v1Protocol) IsChunk(msg *Message) bool {
 	return msg.MsgType == v1Write
 }
 
-func (v *v1Protocol) dispatchUtilityMessage(p protocolHandler, msg *Message) error {
+func (v *v1Protocol) DecodeError(msg *Message) error {
+	return decodeErrorMessage(msg)
+}
+
+func (v *v1Protocol) dispatchUtilityMessage(p ProtocolHandler, msg *Message) error {
 	switch msg.MsgType {
 	case v1Heartbeat:
-		p.onHeartbeat(msg)
+		p.OnHeartbeat(msg)
 	case v1Terminate:
-		p.onTerminate(msg)
+		p.OnTerminate(msg)
 	default:
 		return fmt.Errorf("an invalid utility message type %d", msg.MsgType)
 	}
@@ -72,23 +76,23 @@ func (v *v1Protocol) dispatchUtilityMessage(p protocolHandler, msg *Message) err
 	return nil
 }
 
-func (v *v1Protocol) newHandshake(id string) *Message {
+func (v *v1Protocol) NewHandshake(id string) *Message {
 	return newHandshakeV1(id)
 }
 
-func (v *v1Protocol) newHeartbeat() *Message {
+func (v *v1Protocol) NewHeartbeat() *Message {
 	return newHeartbeatV1()
 }
 
-func (v *v1Protocol) newChoke(session uint64) *Message {
+func (v *v1Protocol) NewChoke(session uint64) *Message {
 	return newChokeV1(session)
 }
 
-func (v *v1Protocol) newChunk(session uint64, data []byte) *Message {
+func (v *v1Protocol) NewChunk(session uint64, data []byte) *Message {
 	return newChunkV1(session, data)
 }
 
-func (v *v1Protocol) newError(session uint64, category, code int, message string) *Message {
+func (v *v1Protocol) NewError(session uint64, category, code int, message string) *Message {
 	return newErrorV1(session, category, code, message)
 }
 